@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// downloadAndParseAllowlist loads IPs and CIDRs that are always reported
+// SAFE from a local path or http(s) URL.
+func (b *Blocklists) downloadAndParseAllowlist(ctx context.Context, source string) error {
+	body, err := b.fetcher.Open(ctx, source)
+	if errors.Is(err, ip.ErrNotModified) {
+		slog.Info("List unchanged since last download", "source", "allowlist")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	networks, err := ip.ParseNetset(body, ip.ParseStatsFrom(ctx))
+	if err != nil {
+		return err
+	}
+	trie := ip.NewPrefixTrie(networks)
+
+	b.swap(func(l *lists) { l.allowed = trie })
+
+	slog.Info("Loaded list", "source", "allowlist", "count", len(networks))
+	b.recordEntries("allowlist", len(networks))
+	return nil
+}
+
+func (l lists) isAllowed(ip net.IP) bool {
+	return l.allowed.Contains(ip)
+}
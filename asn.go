@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// asnList collects AS numbers, written as 13335 or AS13335.
+type asnList []uint
+
+func (l *asnList) String() string {
+	numbers := make([]string, len(*l))
+	for i, asn := range *l {
+		numbers[i] = strconv.FormatUint(uint64(asn), 10)
+	}
+	return strings.Join(numbers, ",")
+}
+
+func (l *asnList) Set(value string) error {
+	for _, asn := range strings.Split(value, ",") {
+		asn = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+		if asn == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(asn, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid AS number %q", asn)
+		}
+		*l = append(*l, uint(n))
+	}
+	return nil
+}
+
+// downloadAndParseASN loads a MaxMind GeoLite2 ASN (or compatible)
+// database, used to allowlist whole networks by AS number. It is reloaded
+// with the lists like the GeoIP database.
+func (b *Blocklists) downloadAndParseASN(ctx context.Context, source string) error {
+	reader, err := openMMDB(ctx, b.fetcher, source)
+	if errors.Is(err, ip.ErrNotModified) {
+		slog.Info("List unchanged since last download", "source", "asn")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	b.swap(func(l *lists) { l.asn = reader })
+
+	slog.Info("Loaded ASN database", "type", reader.Metadata.DatabaseType, "built", reader.Metadata.BuildEpoch)
+	return nil
+}
+
+// allowedASN returns the AS number ip is announced by when it is on the
+// ASN allowlist.
+func (l lists) allowedASN(addr net.IP) (uint, bool) {
+	if l.asn == nil || len(l.allowedASNs) == 0 {
+		return 0, false
+	}
+
+	var record struct {
+		ASN uint `maxminddb:"autonomous_system_number"`
+	}
+	if err := l.asn.Lookup(addr, &record); err != nil || record.ASN == 0 {
+		return 0, false
+	}
+	return record.ASN, slices.Contains(l.allowedASNs, record.ASN)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// Blocklists holds every loaded list together with the cache of answers
+// built from them. Lists are swapped wholesale on reload and never modified
+// in place, so lookups run lock-free on a snapshot, see lists.
+type Blocklists struct {
+	cfg   *Config
+	cache *resultCache
+	// fetcher downloads every list, see Config.fetcher
+	fetcher *ip.Fetcher
+
+	// current is the snapshot lookups read. Reloads build the new one
+	// aside and store it in one go, so readers never take a lock, see swap
+	current atomic.Pointer[lists]
+	// swapMu serializes swaps so each starts from the latest snapshot
+	swapMu sync.Mutex
+
+	// flaggedIPs holds the latest download of each exact-IP list by
+	// source bit, flagged is rebuilt from them, see rebuildFlaggedLocked
+	flaggedIPs map[uint8]ip.IPSet
+	// flaggedBuildMu guards flaggedIPs and serializes rebuilds of flagged
+	// with swaps of the Firehol list they are pruned against
+	flaggedBuildMu sync.Mutex
+
+	// acceptedSizes holds the entry count of the list currently in use for
+	// each source, see checkListSize
+	acceptedSizes   map[string]int
+	acceptedSizesMu sync.Mutex
+	// categories maps sources to their category, see capEntries. Guarded
+	// by acceptedSizesMu
+	categories map[string]string
+
+	// statuses tracks every update attempt for the status TXT, see
+	// recordUpdate
+	statuses   map[string]*sourceStatus
+	statusesMu sync.Mutex
+	// nextRefresh is the earliest scheduled update of any list, in Unix
+	// nanoseconds, kept apart from statuses since every answer reads it
+	nextRefresh atomic.Int64
+	// stale is set while any source is stale, see checkStalenessLocked
+	stale   atomic.Bool
+	started time.Time
+
+	// queries counts answered DNS queries, nil when cfg.TopQueried is 0
+	queries *queryStats
+}
+
+// NewBlocklists returns empty lists for cfg. Nothing is downloaded until
+// Refresh or the periodic updates run.
+func NewBlocklists(cfg *Config) *Blocklists {
+	b := &Blocklists{
+		cfg:           cfg,
+		cache:         newResultCache(cfg.CacheTTL, min(cfg.NegativeCacheTTL, cfg.CacheTTL)),
+		fetcher:       cfg.fetcher(),
+		acceptedSizes: make(map[string]int),
+		categories:    make(map[string]string),
+		flaggedIPs:    make(map[uint8]ip.IPSet),
+		statuses:      make(map[string]*sourceStatus),
+		started:       time.Now(),
+		queries:       newQueryStats(cfg.TopQueried),
+	}
+	b.current.Store(&lists{
+		flagged:     make(map[string]uint8),
+		custom:      make(map[string]*ip.PrefixTrie),
+		allowedASNs: cfg.AllowedASNs,
+		feeds:       cfg.Feeds,
+		priority:    cfg.CategoryPriority,
+	})
+	return b
+}
+
+// Refresh downloads every configured list once, concurrently.
+func (b *Blocklists) Refresh(ctx context.Context) error {
+	return errors.Join(b.runUpdates(ctx, b.updates())...)
+}
+
+// Classify returns every category that applies to ip and the sources that
+// matched, ordered by the configured priority so answers stay cacheable.
+// Clean IPs get a lone SAFE.
+func (b *Blocklists) Classify(ip net.IP) classification {
+	return b.cache.getOrCompute(ip, func(ip net.IP) classification {
+		return b.snapshot().classify(ip)
+	})
+}
+
+// Overlaps reports which lists have entries overlapping network, see
+// lists.overlaps. Results aren't cached.
+func (b *Blocklists) Overlaps(network *net.IPNet) (classification, []overlap) {
+	return b.snapshot().overlaps(network)
+}
+
+// IsBlocked reports whether any blocklist contains ip.
+func (b *Blocklists) IsBlocked(ip net.IP) bool {
+	return len(b.snapshot().blockedMatches(ip)) > 0
+}
+
+func (b *Blocklists) IsDataCenter(ip net.IP) bool {
+	_, ok := b.snapshot().dataCenterNetwork(ip)
+	return ok
+}
+
+func (b *Blocklists) IsProxy(ip net.IP) bool {
+	_, ok := b.snapshot().proxyNetwork(ip)
+	return ok
+}
+
+func (b *Blocklists) IsTorExit(ip net.IP) bool {
+	return b.snapshot().isTorExitNode(ip)
+}
+
+// Loaded reports whether any list currently has entries.
+func (b *Blocklists) Loaded() bool {
+	return b.snapshot().loaded()
+}
+
+// snapshot returns the lists currently in use. Queries take one snapshot
+// each and see the same lists throughout.
+func (b *Blocklists) snapshot() lists {
+	return *b.current.Load()
+}
+
+// swap publishes a copy of the current lists changed by update, then
+// purges the answers cached from the old ones.
+func (b *Blocklists) swap(update func(*lists)) {
+	b.swapMu.Lock()
+	next := *b.current.Load()
+	update(&next)
+	b.current.Store(&next)
+	b.swapMu.Unlock()
+
+	b.cache.purge()
+}
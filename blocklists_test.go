@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// spread scatters i over 32 bits, so synthetic entries don't all sit in
+// the same corner of the address space.
+func spread(i int) uint32 {
+	return uint32(i) * 2654435761
+}
+
+// syntheticLists fills the lists with n IPv4 /24s on Firehol, n IPv6 /48s
+// of data centers and n exact IPs, without touching the network.
+func syntheticLists(n int) func(*lists) {
+	blocked := make([]*net.IPNet, n)
+	dataCenter := make([]*net.IPNet, n)
+	flagged := make(map[string]uint8, n)
+	for i := 0; i < n; i++ {
+		v4 := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(v4, spread(i)&0xffffff00)
+		blocked[i] = &net.IPNet{IP: v4, Mask: net.CIDRMask(24, 32)}
+
+		v6 := make(net.IP, net.IPv6len)
+		v6[0] = 0x2a
+		binary.BigEndian.PutUint32(v6[1:], spread(i))
+		dataCenter[i] = &net.IPNet{IP: v6.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}
+
+		exact := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(exact, spread(i+n))
+		flagged[string(exact.To16())] = sourceIpsum
+	}
+
+	blockedTrie, dataCenterTrie := ip.NewPrefixTrie(blocked), ip.NewPrefixTrie(dataCenter)
+	return func(l *lists) {
+		l.blocked = blockedTrie
+		l.dataCenter = dataCenterTrie
+		l.flagged = flagged
+	}
+}
+
+// syntheticQueries returns n addresses, every other one inside a Firehol
+// network of syntheticLists.
+func syntheticQueries(n int) []net.IP {
+	addrs := make([]net.IP, n)
+	for i := range addrs {
+		addrs[i] = make(net.IP, net.IPv4len)
+		if i%2 == 0 {
+			binary.BigEndian.PutUint32(addrs[i], spread(i/2)&0xffffff00|7)
+		} else {
+			binary.BigEndian.PutUint32(addrs[i], ^spread(i))
+		}
+	}
+	return addrs
+}
+
+// TestClassifyDuringSwap classifies from several goroutines while the
+// lists flip between two versions; run it with -race. Every answer has to
+// match one of the versions, never a mix or a stale cache entry.
+func TestClassifyDuringSwap(t *testing.T) {
+	loaded, empty := syntheticLists(1_000), func(l *lists) { *l = lists{} }
+	addrs := syntheticQueries(256)
+
+	want := make(map[string]bool)
+	for _, update := range []func(*lists){loaded, empty} {
+		l := newTestBlocklists(defaultConfig(), update).snapshot()
+		for _, addr := range addrs {
+			want[addr.String()+" "+fmt.Sprint(l.classify(addr).Labels)] = true
+		}
+	}
+
+	b := newTestBlocklists(defaultConfig(), loaded)
+	done := make(chan struct{})
+	var swaps sync.WaitGroup
+	swaps.Add(1)
+	go func() {
+		defer swaps.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				b.swap(empty)
+			} else {
+				b.swap(loaded)
+			}
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for i := 0; i < 2_000; i++ {
+				addr := addrs[(i+r)%len(addrs)]
+				got := b.Classify(addr)
+				if key := addr.String() + " " + fmt.Sprint(got.Labels); !want[key] {
+					t.Errorf("Classify(%s) = %v, not an answer of either list version", addr, got.Labels)
+					return
+				}
+			}
+		}(r)
+	}
+	readers.Wait()
+	close(done)
+	swaps.Wait()
+}
+
+// BenchmarkClassifyDuringReload looks addresses up from every CPU, with
+// and without a reload swapping the lists underneath all the while.
+func BenchmarkClassifyDuringReload(b *testing.B) {
+	for _, reload := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reload=%v", reload), func(b *testing.B) {
+			blocklists := newTestBlocklists(defaultConfig(), syntheticLists(10_000))
+			addrs := syntheticQueries(1024)
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			if reload {
+				next := syntheticLists(10_000)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+							blocklists.swap(next)
+						}
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					blocklists.snapshot().classify(addrs[i%len(addrs)])
+				}
+			})
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkClassify(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			l := newTestBlocklists(defaultConfig(), syntheticLists(n)).snapshot()
+			addrs := syntheticQueries(1024)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.classify(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
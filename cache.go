@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+// Bounds on how many classified IPs each cache keeps in memory.
+const (
+	resultCacheSize   = 10000
+	negativeCacheSize = 10000
+)
+
+// resultCache remembers recent classifications so hot IPs skip the list
+// scans. SAFE answers, by far the most common, go to a separate negative
+// LRU with a shorter TTL so a flood of unique benign IPs can't evict the
+// listed ones. It must be purged whenever a list is swapped, see purge.
+type resultCache struct {
+	positive *lruCache
+	negative *lruCache
+}
+
+func newResultCache(ttl, negativeTTL time.Duration) *resultCache {
+	return &resultCache{
+		positive: newLRUCache("positive", resultCacheSize, ttl),
+		negative: newLRUCache("negative", negativeCacheSize, negativeTTL),
+	}
+}
+
+// getOrCompute returns the cached classification for ip, calling classify on
+// a miss. Results computed across a purge are not stored since they may
+// have been built from the old lists.
+func (c *resultCache) getOrCompute(ip net.IP, classify func(net.IP) classification) classification {
+	key := string(ip.To16())
+
+	positive, ok := c.positive.get(key)
+	if ok {
+		return positive.result
+	}
+	negative, ok := c.negative.get(key)
+	if ok {
+		return negative.result
+	}
+
+	result := classify(ip)
+	if result.safe() {
+		c.negative.put(key, result, negative.generation)
+	} else {
+		c.positive.put(key, result, positive.generation)
+	}
+	return result
+}
+
+func (c *resultCache) purge() {
+	c.positive.purge()
+	c.negative.purge()
+}
+
+type cacheEntry struct {
+	key     string
+	result  classification
+	expires time.Time
+}
+
+// lookup is the outcome of lruCache.get: the cached result on a hit, or the
+// generation to hand back to put on a miss.
+type lookup struct {
+	result     classification
+	generation uint64
+}
+
+// lruCache is a size and time bounded cache of classifications.
+type lruCache struct {
+	name       string
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	generation uint64
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+func newLRUCache(name string, size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		name:    name,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lruCache) get(key string) (lookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			cacheLookups.WithLabelValues(c.name, "hit").Inc()
+			return lookup{result: entry.result}, true
+		}
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+	cacheLookups.WithLabelValues(c.name, "miss").Inc()
+	return lookup{generation: c.generation}, false
+}
+
+// put stores result unless the cache was purged since generation was
+// handed out by get.
+func (c *lruCache) put(key string, result classification, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:     key,
+		result:  result,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
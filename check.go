@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// runCheck implements `ipshield check [flags] IP...`: it loads every list
+// once, prints the classification of each IP and returns the exit code,
+// without starting any server.
+//
+// Each IP gets one tab separated line of the address, its categories and
+// the sources that matched, e.g. "1.2.3.4	FLAGGED	firehol,ipsum", followed by
+// the country when a GeoIP database is configured.
+func runCheck(args []string, stdout io.Writer) int {
+	cfg, addrs, err := loadConfig(args)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return 2
+	}
+	if len(addrs) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ipshield check [flags] IP...")
+		return 2
+	}
+
+	parsed := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		if parsed[i] = ip.Canonical(net.ParseIP(addr)); parsed[i] == nil {
+			fmt.Fprintf(os.Stderr, "invalid IP address %q\n", addr)
+			return 2
+		}
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	// Lists that fail to download are left empty, the same as a server that
+	// has just started, so a partial answer is still printed
+	blocklists := NewBlocklists(cfg)
+	if err := blocklists.Refresh(context.Background()); err != nil && !blocklists.Loaded() {
+		slog.Error("No lists could be loaded", "error", err)
+		return 1
+	}
+
+	for _, addr := range parsed {
+		result := blocklists.Classify(addr)
+		if cfg.SingleCategory {
+			result = result.top()
+		}
+		line := fmt.Sprintf("%s\t%s\t%s", addr, strings.Join(result.Categories, ","), strings.Join(result.Sources, ","))
+		if cfg.GeoIPDatabase != "" {
+			line += "\t" + result.Country
+		}
+		fmt.Fprintln(stdout, line)
+	}
+	return 0
+}
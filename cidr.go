@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// maxOverlaps bounds the entries reported for one network query, a short
+// prefix can overlap most of a list.
+const maxOverlaps = 100
+
+// overlap is a list entry that overlaps a queried network.
+type overlap struct {
+	Category string
+	Source   string
+	Network  *net.IPNet
+}
+
+func (o overlap) String() string {
+	return o.Category + ":" + o.Source + " " + o.Network.String()
+}
+
+// parseQueryCIDR accepts a question name that is a CIDR such as
+// 203.0.113.0/24 or 2001:db8::/32.
+func parseQueryCIDR(name string) (*net.IPNet, bool) {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.Contains(name, "/") {
+		return nil, false
+	}
+	_, network, err := net.ParseCIDR(name)
+	if err != nil {
+		return nil, false
+	}
+	network = ip.CanonicalNetwork(network)
+	return network, network != nil
+}
+
+// overlaps checks network against every list, returning the categories it
+// touches and up to maxOverlaps matching entries. Each source is checked on
+// its own, so one with many entries in network can't hide the others once
+// the entries run out. Unlike classify this ignores the allowlist: it
+// answers whether any part of the network is listed, not how a single
+// address would be answered.
+func (l lists) overlaps(network *net.IPNet) (classification, []overlap) {
+	var result classification
+	var matches []overlap
+
+	// Asking for at least one entry tells whether the source overlaps at
+	// all, even once matches is full
+	add := func(category, source string, entries []*net.IPNet) {
+		if len(entries) == 0 {
+			return
+		}
+		result.add(category, source)
+		for _, entry := range entries[:min(len(entries), maxOverlaps-len(matches))] {
+			matches = append(matches, overlap{category, source, entry})
+		}
+	}
+	addTrie := func(category, source string, trie *ip.PrefixTrie) {
+		add(category, source, trie.Overlapping(network, max(maxOverlaps-len(matches), 1)))
+	}
+	addAddrs := func(category, source string, index ip.SortedIPs) {
+		var entries []*net.IPNet
+		for _, addr := range index.Overlapping(network, max(maxOverlaps-len(matches), 1)) {
+			entries = append(entries, ip.HostNetwork(addr))
+		}
+		add(category, source, entries)
+	}
+
+	addTrie(categoryFlagged, "firehol", l.blocked)
+	addTrie(categoryFlagged, "drop", l.drop)
+	for _, bit := range []uint8{sourceIpsum, sourceGreensnow, sourceAbuseIPDB} {
+		addAddrs(categoryFlagged, flaggedSourceNames(bit)[0], l.flaggedIndex[bit])
+	}
+	addTrie(categoryDataCenter, "datacenter", l.dataCenter)
+	addAddrs(categoryTorExit, "tor", l.torExitIndex)
+	addTrie(categoryProxy, "proxy", l.proxy)
+	addTrie(categoryCDN, "cloudflare", l.cdn)
+	for _, feed := range l.feeds {
+		addTrie(feed.Label, feed.source(), l.custom[feed.Label])
+	}
+
+	result.Score = sourceScore(result.Sources)
+	if len(result.Categories) == 0 {
+		result.Categories = []string{categorySafe}
+	}
+	return result.prioritized(l.priority), matches
+}
+
+// overlapStrings describes the matches in categories still present in
+// result, as "CATEGORY:source network" TXT strings.
+func overlapStrings(result classification, matches []overlap) []string {
+	var txt []string
+	for _, match := range matches {
+		if slices.Contains(result.Categories, match.Category) {
+			txt = append(txt, match.String())
+		}
+	}
+	return txt
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+func TestOverlapsReportsEverySource(t *testing.T) {
+	var firehol []string
+	for i := 0; i < 2*maxOverlaps; i++ {
+		firehol = append(firehol, fmt.Sprintf("44.%d.%d.0/24", i/256, i%256))
+	}
+
+	b := NewBlocklists(defaultConfig())
+	b.swapFirehol(ip.NewPrefixTrie(mustParseCIDRs(t, firehol...)))
+	b.swapFlaggedIPs("Greensnow", sourceGreensnow, ipSet("44.200.0.1", "44.0.5.200", "45.0.0.1"))
+	b.swap(func(l *lists) {
+		l.dataCenter = ip.NewPrefixTrie(mustParseCIDRs(t, "44.250.0.0/16"))
+		tor := ipSet("44.250.7.9", "44.250.7.1", "2001:db8::1")
+		l.torExit, l.torExitIndex = tor, ip.NewSortedIPs(tor)
+	})
+
+	result, matches := b.Overlaps(mustParseCIDRs(t, "44.0.0.0/8")[0])
+	wantLabels := []string{"FLAGGED:firehol", "FLAGGED:greensnow", "DATACENTER:datacenter", "TOR_EXIT:tor"}
+	if !slices.Equal(result.Labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", result.Labels, wantLabels)
+	}
+	if len(matches) != maxOverlaps {
+		t.Errorf("%d matches, want the cap of %d", len(matches), maxOverlaps)
+	}
+	if matches[0].Network.String() != "44.0.0.0/24" || matches[len(matches)-1].Network.String() != "44.0.99.0/24" {
+		t.Errorf("matches run %s to %s, want the first %d Firehol networks in order", matches[0], matches[len(matches)-1], maxOverlaps)
+	}
+
+	tests := []struct {
+		network string
+		labels  []string
+		matches []string
+	}{
+		{"44.250.7.0/24", []string{"DATACENTER:datacenter", "TOR_EXIT:tor"},
+			[]string{"DATACENTER:datacenter 44.250.0.0/16", "TOR_EXIT:tor 44.250.7.1/32", "TOR_EXIT:tor 44.250.7.9/32"}},
+		{"44.200.0.0/16", []string{"FLAGGED:greensnow"}, []string{"FLAGGED:greensnow 44.200.0.1/32"}},
+		// Pruned by Firehol, so only its network answers
+		{"44.0.5.128/25", []string{"FLAGGED:firehol"}, []string{"FLAGGED:firehol 44.0.5.0/24"}},
+		{"2001:db8::/32", []string{"TOR_EXIT:tor"}, []string{"TOR_EXIT:tor 2001:db8::1/128"}},
+		{"::/0", []string{"TOR_EXIT:tor"}, []string{"TOR_EXIT:tor 2001:db8::1/128"}},
+		{"46.0.0.0/8", nil, nil},
+	}
+	for _, tt := range tests {
+		result, matches := b.Overlaps(mustParseCIDRs(t, tt.network)[0])
+		var got []string
+		for _, match := range matches {
+			got = append(got, match.String())
+		}
+		if !slices.Equal(result.Labels, tt.labels) || !slices.Equal(got, tt.matches) {
+			t.Errorf("Overlaps(%s) = %v %v, want %v %v", tt.network, result.Labels, got, tt.labels, tt.matches)
+		}
+	}
+}
+
+func TestOverlapsSafe(t *testing.T) {
+	result, matches := NewBlocklists(defaultConfig()).Overlaps(mustParseCIDRs(t, "0.0.0.0/0")[0])
+	if !result.safe() || len(matches) != 0 {
+		t.Errorf("Overlaps with no lists = %v %v, want a lone SAFE", result.Categories, matches)
+	}
+}
@@ -0,0 +1,164 @@
+// Package client looks up IPs on an ipshield server over DNS, building the
+// query names the server parses and reading its TXT answers.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Category is the classification of an address, as answered by the server.
+type Category string
+
+const (
+	Flagged    Category = "FLAGGED"
+	DataCenter Category = "DATACENTER"
+	TorExit    Category = "TOR_EXIT"
+	Proxy      Category = "PROXY"
+	CDN        Category = "CDN"
+	Safe       Category = "SAFE"
+	Private    Category = "PRIVATE"
+	Reserved   Category = "RESERVED"
+)
+
+// Source is a list that matched an address, e.g. {FLAGGED firehol}.
+type Source struct {
+	Category Category
+	Name     string
+}
+
+// ErrNotReady is returned while the server has no list loaded yet and
+// answers SERVFAIL.
+var ErrNotReady = errors.New("ipshield is not ready")
+
+// Client queries one ipshield server. The zero value is not usable, Addr
+// must be set.
+type Client struct {
+	// Addr is the server, or a resolver forwarding the zone to it, as
+	// host:port
+	Addr string
+	// Zone is the DNSBL zone the server answers for, its -zone. When empty
+	// addresses are asked for under in-addr.arpa and ip6.arpa, which only
+	// works when talking to the server itself.
+	Zone string
+	// Net is "udp", the default, "tcp" or "tcp-tls"
+	Net string
+}
+
+// Lookup asks the ipshield server at resolverAddr about addr, see
+// Client.Lookup.
+func Lookup(ctx context.Context, resolverAddr string, addr net.IP) (Category, []Source, error) {
+	c := &Client{Addr: resolverAddr}
+	return c.Lookup(ctx, addr)
+}
+
+// Lookup returns the leading category of addr, the one with the highest
+// priority on the server, and every source that matched. Servers that
+// answer unlisted addresses with NXDOMAIN or an empty answer, see
+// -safe-answer, report them as Safe.
+func (c *Client) Lookup(ctx context.Context, addr net.IP) (Category, []Source, error) {
+	name := QueryName(addr, c.Zone)
+	if name == "" {
+		return "", nil, fmt.Errorf("invalid IP address %v", addr)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	// Large answers, with many sources, need more than 512 bytes
+	m.SetEdns0(dns.DefaultMsgSize, false)
+
+	dnsClient := &dns.Client{Net: c.Net}
+	r, _, err := dnsClient.ExchangeContext(ctx, m, c.Addr)
+	if err == nil && r.Truncated && c.Net == "" {
+		dnsClient.Net = "tcp"
+		r, _, err = dnsClient.ExchangeContext(ctx, m, c.Addr)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return Safe, nil, nil
+	case dns.RcodeServerFailure:
+		return "", nil, ErrNotReady
+	default:
+		return "", nil, fmt.Errorf("%s answered %s for %s", c.Addr, dns.RcodeToString[r.Rcode], name)
+	}
+
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			category, sources := parseTXT(txt.Txt)
+			return category, sources, nil
+		}
+	}
+	return Safe, nil, nil
+}
+
+// parseTXT reads an answer such as "FLAGGED" "TOR_EXIT" "FLAGGED:ipsum"
+// "TOR_EXIT:tor" "CC:DE". Strings other than the categories and their
+// sources are skipped, as are the networks following sources with
+// -txt-matched-cidr.
+func parseTXT(txt []string) (Category, []Source) {
+	var categories []string
+	var sources []Source
+	for _, s := range txt {
+		label, rest, ok := strings.Cut(s, ":")
+		if !ok {
+			if s != "STALE" {
+				categories = append(categories, s)
+			}
+			continue
+		}
+		if !slices.Contains(categories, label) {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, " ")
+		source := Source{Category(label), name}
+		if !slices.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	if len(categories) == 0 {
+		return Safe, nil
+	}
+	return Category(categories[0]), sources
+}
+
+// QueryName returns the fully qualified name the server looks addr up by:
+// the reversed octets, or for IPv6 the 32 reversed nibbles, followed by
+// zone, or by in-addr.arpa and ip6.arpa when zone is empty. It returns ""
+// for anything that isn't an IP.
+func QueryName(addr net.IP, zone string) string {
+	if v4 := addr.To4(); v4 != nil {
+		if zone == "" {
+			zone = "in-addr.arpa"
+		}
+		return dns.Fqdn(fmt.Sprintf("%d.%d.%d.%d.%s", v4[3], v4[2], v4[1], v4[0], zone))
+	}
+
+	v6 := addr.To16()
+	if v6 == nil {
+		return ""
+	}
+	if zone == "" {
+		zone = "ip6.arpa"
+	}
+	var b strings.Builder
+	for i := len(v6) - 1; i >= 0; i-- {
+		b.WriteString(strconv.FormatUint(uint64(v6[i]&0xf), 16))
+		b.WriteByte('.')
+		b.WriteString(strconv.FormatUint(uint64(v6[i]>>4), 16))
+		b.WriteByte('.')
+	}
+	b.WriteString(zone)
+	return dns.Fqdn(b.String())
+}
@@ -0,0 +1,728 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/scmmishra/ipshield/internal/ip"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tunable. Values come from the defaults below, then the
+// YAML file given with -config, then IPSHIELD_* environment variables and
+// finally command line flags, each overriding the last.
+type Config struct {
+	Listen           string          `yaml:"listen"`
+	HTTPListen       string          `yaml:"http_listen"`
+	PprofListen      string          `yaml:"pprof_listen"`
+	AdminToken       string          `yaml:"admin_token"`
+	StatusName       string          `yaml:"status_name"`
+	PTRDomain        string          `yaml:"ptr_domain"`
+	Zone             string          `yaml:"zone"`
+	CacheTTL         time.Duration   `yaml:"cache_ttl"`
+	NegativeCacheTTL time.Duration   `yaml:"negative_cache_ttl"`
+	CategoryTTL      categoryTTLs    `yaml:"category_ttl"`
+	UpdateInterval   time.Duration   `yaml:"update_interval"`
+	RetryDelay       time.Duration   `yaml:"retry_delay"`
+	MaxRetryDelay    time.Duration   `yaml:"max_retry_delay"`
+	FailingIntervals int             `yaml:"failing_intervals"`
+	Staleness        StalenessConfig `yaml:"staleness"`
+	MinListRatio     float64         `yaml:"min_list_ratio"`
+	MinEntries       map[string]int  `yaml:"min_entries"`
+	MaxEntries       map[string]int  `yaml:"max_entries"`
+	FireholLevel     int             `yaml:"firehol_level"`
+	IpsumMinScore    int             `yaml:"ipsum_min_score"`
+	CategoryPriority []string        `yaml:"category_priority"`
+	SingleCategory   bool            `yaml:"single_category"`
+	BlockCategories  []string        `yaml:"block_categories"`
+	SafeAnswer       string          `yaml:"safe_answer"`
+	FailOpen         bool            `yaml:"fail_open"`
+	TXTScore         bool            `yaml:"txt_score"`
+	TXTMatchedCIDR   bool            `yaml:"txt_matched_cidr"`
+	MaxBatch         int             `yaml:"max_batch"`
+	TopQueried       int             `yaml:"top_queried"`
+	Resolver         ResolverConfig  `yaml:"resolver"`
+	LogLevel         slog.Level      `yaml:"log_level"`
+	Allowlist        string          `yaml:"allowlist"`
+	GeoIPDatabase    string          `yaml:"geoip_database"`
+	ASNDatabase      string          `yaml:"asn_database"`
+	AllowedASNs      asnList         `yaml:"allowlist_asns"`
+	RateLimit        RateLimitConfig `yaml:"rate_limit"`
+	AbuseIPDB        AbuseIPDBConfig `yaml:"abuseipdb"`
+	Ranges           ip.RangeURLs    `yaml:"ranges"`
+	DownloadTimeout  time.Duration   `yaml:"download_timeout"`
+	Proxy            string          `yaml:"proxy"`
+	UserAgent        string          `yaml:"user_agent"`
+	Contact          string          `yaml:"contact"`
+	CacheDir         string          `yaml:"cache_dir"`
+	FetchConcurrency int             `yaml:"fetch_concurrency"`
+	Sources          SourceURLs      `yaml:"sources"`
+	Disabled         sourceList      `yaml:"disabled"`
+	Feeds            feedList        `yaml:"feeds"`
+}
+
+// SourceURLs locates the built-in lists. Each may also be a file:// URL or
+// a local path. Proxy, a netset of residential proxy and VPN networks, has
+// no default since those lists vary a lot.
+type SourceURLs struct {
+	Firehol   string `yaml:"firehol"`
+	Tor       string `yaml:"tor"`
+	Ipsum     string `yaml:"ipsum"`
+	Greensnow string `yaml:"greensnow"`
+	Drop      string `yaml:"spamhaus_drop"`
+	Edrop     string `yaml:"spamhaus_edrop"`
+	Azure     string `yaml:"azure"`
+	Proxy     string `yaml:"proxy"`
+}
+
+// fireholLevelURL returns the netset of a Firehol level. Level 1 is meant
+// to be safe to block outright, levels 2 and 3 add lists with more false
+// positives.
+func fireholLevelURL(level int) string {
+	return fmt.Sprintf("https://iplists.firehol.org/files/firehol_level%d.netset", level)
+}
+
+// RateLimitConfig bounds DNS queries per second, per client address and in
+// total. A QPS of 0 turns that limit off. Refused queries get REFUSED.
+type RateLimitConfig struct {
+	ClientQPS   float64 `yaml:"client_qps"`
+	ClientBurst int     `yaml:"client_burst"`
+	GlobalQPS   float64 `yaml:"global_qps"`
+	GlobalBurst int     `yaml:"global_burst"`
+}
+
+// ResolverConfig bounds the name resolution of /lookup-host. AllowPrivate
+// classifies private and reserved addresses a name resolves to, which are
+// refused otherwise.
+type ResolverConfig struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	AllowPrivate bool          `yaml:"allow_private"`
+}
+
+// fetcher returns a downloader configured from c. Each has its own client
+// and download state, so Blocklists built from one config share nothing.
+func (c *Config) fetcher() *ip.Fetcher {
+	// validate made sure the proxy parses
+	proxy, _ := c.proxyURL()
+	ranges := c.Ranges
+	ranges.AzureServiceTags = c.Sources.Azure
+	return &ip.Fetcher{
+		Client:                ip.NewHTTPClient(c.DownloadTimeout, proxy),
+		UserAgent:             c.UserAgent,
+		Contact:               c.Contact,
+		CacheDir:              c.CacheDir,
+		Ranges:                ranges,
+		DataCenterConcurrency: c.FetchConcurrency,
+	}
+}
+
+// proxyURL parses Proxy, nil when downloads should follow the proxy
+// environment variables.
+func (c *Config) proxyURL() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected a URL such as http://proxy:3128", c.Proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+}
+
+// StalenessConfig bounds how old a list may get before answers stop relying
+// on it quietly. MaxAge applies to every source without an entry in
+// Sources, 0 turns the check off.
+type StalenessConfig struct {
+	MaxAge  time.Duration            `yaml:"max_age"`
+	Sources map[string]time.Duration `yaml:"sources"`
+	// Policy is stalenessWarn or stalenessDegrade
+	Policy string `yaml:"policy"`
+}
+
+const (
+	// stalenessWarn logs stale lists and reports them in the status TXT
+	stalenessWarn = "warn"
+	// stalenessDegrade also fails readiness and marks answers STALE
+	stalenessDegrade = "degrade"
+)
+
+// SafeAnswer controls how addresses on no list are answered.
+//
+// Classic DNSBL clients treat any answer as "listed" and expect NXDOMAIN
+// otherwise, so they need safeAnswerNXDomain. It can't tell a clean address
+// from a mistyped zone, and since no SOA is sent resolvers cache the
+// negative answer for their own default time rather than the answer TTL.
+// safeAnswerNoData answers NOERROR with no records instead, which keeps the
+// name existing but is likewise cached without a TTL from us.
+const (
+	// safeAnswerTXT answers TXT queries with SAFE and A queries with no
+	// records
+	safeAnswerTXT      = "txt"
+	safeAnswerNXDomain = "nxdomain"
+	safeAnswerNoData   = "nodata"
+)
+
+func (s StalenessConfig) maxAge(source string) time.Duration {
+	if maxAge, ok := s.Sources[source]; ok {
+		return maxAge
+	}
+	return s.MaxAge
+}
+
+// AbuseIPDBConfig enables the AbuseIPDB blacklist when APIKey is set.
+type AbuseIPDBConfig struct {
+	APIKey        string `yaml:"api_key"`
+	URL           string `yaml:"url"`
+	MinConfidence int    `yaml:"min_confidence"`
+}
+
+// builtinSources are the lists that can be switched off with Disabled.
+var builtinSources = []string{"firehol", "drop", "tor", "ipsum", "greensnow", "abuseipdb", "datacenter", "cdn", "proxy"}
+
+// sourceList collects comma separated source names.
+type sourceList []string
+
+func (l *sourceList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *sourceList) Set(value string) error {
+	for _, source := range strings.Split(value, ",") {
+		if source = strings.ToLower(strings.TrimSpace(source)); source != "" {
+			*l = append(*l, source)
+		}
+	}
+	return nil
+}
+
+// categoryTTLs maps categories to the TTL of answers led by them, see
+// answerTTL.
+type categoryTTLs map[string]time.Duration
+
+func (t *categoryTTLs) String() string {
+	pairs := make([]string, 0, len(*t))
+	for category, ttl := range *t {
+		pairs = append(pairs, category+"="+ttl.String())
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set parses CATEGORY=TTL pairs, replacing any TTLs set before.
+func (t *categoryTTLs) Set(value string) error {
+	*t = make(categoryTTLs)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		category, ttl, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected CATEGORY=TTL, got %q", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(ttl))
+		if err != nil {
+			return err
+		}
+		(*t)[strings.TrimSpace(category)] = d
+	}
+	return nil
+}
+
+// enabled reports whether a built-in source should be downloaded.
+func (c *Config) enabled(source string) bool {
+	return !slices.Contains(c.Disabled, source)
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:           ":53",
+		StatusName:       "status.ipshield",
+		CacheTTL:         time.Hour,
+		NegativeCacheTTL: 5 * time.Minute,
+		UpdateInterval:   6 * time.Hour,
+		RetryDelay:       5 * time.Second,
+		MaxRetryDelay:    5 * time.Minute,
+		FailingIntervals: 2,
+		Staleness: StalenessConfig{
+			MaxAge: 24 * time.Hour,
+			Policy: stalenessWarn,
+		},
+		MinListRatio:     0.5,
+		SafeAnswer:       safeAnswerTXT,
+		FireholLevel:     1,
+		IpsumMinScore:    1,
+		MaxBatch:         1000,
+		TopQueried:       100,
+		Resolver:         ResolverConfig{Timeout: 5 * time.Second},
+		CategoryPriority: slices.Clone(defaultCategoryPriority),
+		DownloadTimeout:  ip.DefaultDownloadTimeout,
+		UserAgent:        ip.DefaultUserAgent(),
+		FetchConcurrency: ip.DefaultDataCenterConcurrency,
+		Ranges:           ip.DefaultRangeURLs(),
+		AbuseIPDB: AbuseIPDBConfig{
+			URL:           ip.AbuseIPDBBlacklistURL,
+			MinConfidence: 90,
+		},
+		RateLimit: RateLimitConfig{
+			ClientBurst: 20,
+			GlobalBurst: 1000,
+		},
+		Sources: SourceURLs{
+			Tor:       "https://check.torproject.org/torbulkexitlist",
+			Ipsum:     "https://raw.githubusercontent.com/stamparm/ipsum/master/ipsum.txt",
+			Greensnow: "https://blocklist.greensnow.co/greensnow.txt",
+			Drop:      "https://www.spamhaus.org/drop/drop.txt",
+			Edrop:     "https://www.spamhaus.org/drop/edrop.txt",
+		},
+	}
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address the DNS server binds to")
+	fs.StringVar(&c.HTTPListen, "http-listen", c.HTTPListen, "address for the HTTP lookup API, metrics and health checks, disabled when empty")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token for POST /admin/reload, off when empty")
+	fs.StringVar(&c.PprofListen, "pprof-listen", c.PprofListen, "address for the pprof profiling endpoints, e.g. 127.0.0.1:6060, off when empty")
+	fs.StringVar(&c.Zone, "zone", c.Zone, "DNSBL zone for reverse-octet queries (e.g. bl.example.com)")
+	fs.StringVar(&c.StatusName, "status-name", c.StatusName, "TXT name answered with the update time and size of every list, empty to disable")
+	fs.StringVar(&c.PTRDomain, "ptr-domain", c.PTRDomain, "answer PTR queries with <category>.<domain>, e.g. flagged.ipshield, empty to refuse them")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "TTL of DNS answers and cached classifications")
+	fs.DurationVar(&c.NegativeCacheTTL, "negative-cache-ttl", c.NegativeCacheTTL, "how long SAFE classifications stay cached, capped at -cache-ttl")
+	fs.Var(&c.CategoryTTL, "category-ttl", "comma separated CATEGORY=TTL answer TTLs used instead of -cache-ttl, e.g. FLAGGED=6h,SAFE=5m")
+	fs.DurationVar(&c.UpdateInterval, "update-interval", c.UpdateInterval, "how often every list is refreshed")
+	fs.DurationVar(&c.RetryDelay, "retry-delay", c.RetryDelay, "first retry delay after a failed download, doubled on each further failure")
+	fs.DurationVar(&c.MaxRetryDelay, "max-retry-delay", c.MaxRetryDelay, "upper bound for the retry delay")
+	fs.IntVar(&c.FailingIntervals, "failing-intervals", c.FailingIntervals, "update intervals a source may keep failing before it is reported as FAILING")
+	fs.DurationVar(&c.Staleness.MaxAge, "max-staleness", c.Staleness.MaxAge, "age after which a list that failed to update counts as stale, 0 to never")
+	fs.StringVar(&c.Staleness.Policy, "staleness-policy", c.Staleness.Policy, "warn only logs stale lists, degrade also fails /readyz and adds STALE to TXT answers")
+	fs.TextVar(&c.LogLevel, "log-level", c.LogLevel, "minimum level logged: debug, info, warn or error")
+	fs.Float64Var(&c.RateLimit.ClientQPS, "client-qps", c.RateLimit.ClientQPS, "DNS queries per second allowed from one client address, 0 for no limit")
+	fs.IntVar(&c.RateLimit.ClientBurst, "client-burst", c.RateLimit.ClientBurst, "queries a client may send at once before -client-qps applies")
+	fs.Float64Var(&c.RateLimit.GlobalQPS, "global-qps", c.RateLimit.GlobalQPS, "DNS queries per second allowed across all clients, 0 for no limit")
+	fs.IntVar(&c.RateLimit.GlobalBurst, "global-burst", c.RateLimit.GlobalBurst, "queries accepted at once before -global-qps applies")
+	fs.Float64Var(&c.MinListRatio, "min-list-ratio", c.MinListRatio, "reject downloads with fewer than this fraction of the previous entries, 0 to only reject empty lists")
+	fs.IntVar(&c.FireholLevel, "firehol-level", c.FireholLevel, "Firehol list level, 1 to 3, higher levels flag more IPs at the cost of more false positives")
+	fs.IntVar(&c.IpsumMinScore, "ipsum-min-score", c.IpsumMinScore, "only flag IPsum entries listed by at least this many blocklists")
+	fs.Func("category-priority", "comma separated categories, most important first (default "+strings.Join(defaultCategoryPriority, ",")+")", func(value string) error {
+		c.CategoryPriority = splitCategories(value)
+		return nil
+	})
+	fs.BoolVar(&c.SingleCategory, "single-category", c.SingleCategory, "answer DNS queries with only the highest priority category")
+	fs.Func("block-categories", "comma separated categories answered 127.0.0.2 like FLAGGED in A records, e.g. DATACENTER,TOR_EXIT", func(value string) error {
+		c.BlockCategories = splitCategories(value)
+		return nil
+	})
+	fs.StringVar(&c.SafeAnswer, "safe-answer", c.SafeAnswer, "answer for addresses on no list: txt for a SAFE TXT record, nxdomain or nodata for an empty NOERROR")
+	fs.BoolVar(&c.FailOpen, "fail-open", c.FailOpen, "answer lookups from empty lists before any has loaded instead of failing them with SERVFAIL and 503")
+	fs.BoolVar(&c.TXTScore, "txt-score", c.TXTScore, "add SCORE:<n>, the number of sources that matched, to TXT answers")
+	fs.BoolVar(&c.TXTMatchedCIDR, "txt-matched-cidr", c.TXTMatchedCIDR, "add the list entry each source matched, as CATEGORY:source CIDR, to TXT answers")
+	fs.IntVar(&c.MaxBatch, "max-batch", c.MaxBatch, "maximum number of IPs in one bulk HTTP lookup")
+	fs.IntVar(&c.TopQueried, "top-queried", c.TopQueried, "most queried IPs tracked for GET /admin/stats, 0 turns query statistics off")
+	fs.DurationVar(&c.Resolver.Timeout, "host-lookup-timeout", c.Resolver.Timeout, "time limit for resolving a name in /lookup-host")
+	fs.BoolVar(&c.Resolver.AllowPrivate, "host-lookup-private", c.Resolver.AllowPrivate, "classify private and reserved addresses a name in /lookup-host resolves to instead of refusing them")
+	fs.StringVar(&c.Allowlist, "allowlist", c.Allowlist, "file or URL of IPs and CIDRs always reported SAFE")
+	fs.StringVar(&c.GeoIPDatabase, "geoip-db", c.GeoIPDatabase, "file or URL of a MaxMind country database (.mmdb), adds the country to answers")
+	fs.StringVar(&c.ASNDatabase, "asn-db", c.ASNDatabase, "file or URL of a MaxMind ASN database (.mmdb), needed by -allowlist-asn")
+	fs.Var(&c.AllowedASNs, "allowlist-asn", "comma separated AS numbers whose addresses are always reported SAFE, may be repeated")
+	fs.StringVar(&c.Sources.Firehol, "firehol-url", c.Sources.Firehol, "Firehol netset URL or file path, the netset for -firehol-level when empty")
+	fs.StringVar(&c.Sources.Tor, "tor-url", c.Sources.Tor, "Tor exit node list URL or file path")
+	fs.StringVar(&c.Sources.Ipsum, "ipsum-url", c.Sources.Ipsum, "IPsum list URL or file path")
+	fs.StringVar(&c.Sources.Greensnow, "greensnow-url", c.Sources.Greensnow, "Greensnow list URL or file path")
+	fs.StringVar(&c.Sources.Drop, "drop-url", c.Sources.Drop, "Spamhaus DROP list URL or file path")
+	fs.StringVar(&c.Sources.Edrop, "edrop-url", c.Sources.Edrop, "Spamhaus EDROP list URL or file path, skipped when empty")
+	fs.StringVar(&c.Sources.Proxy, "proxy-list-url", c.Sources.Proxy, "residential proxy and VPN netset URL or file path, reported as PROXY, skipped when empty")
+	fs.IntVar(&c.AbuseIPDB.MinConfidence, "abuseipdb-min-confidence", c.AbuseIPDB.MinConfidence, "lowest AbuseIPDB confidence score (25-100) reported as FLAGGED")
+	fs.DurationVar(&c.DownloadTimeout, "download-timeout", c.DownloadTimeout, "time limit for a single download, including reading the body")
+	fs.StringVar(&c.Proxy, "proxy", c.Proxy, "proxy URL for every download, HTTPS_PROXY and HTTP_PROXY are used when empty")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "User-Agent header sent with every download")
+	fs.StringVar(&c.Contact, "contact", c.Contact, "contact, e.g. an email address, sent in the From header of every download so list maintainers can reach you")
+	fs.StringVar(&c.CacheDir, "cache-dir", c.CacheDir, "directory keeping the last good download of every list, loaded at startup before the network")
+	fs.IntVar(&c.FetchConcurrency, "fetch-concurrency", c.FetchConcurrency, "data center providers downloaded at once")
+	fs.StringVar(&c.Sources.Azure, "azure-url", c.Sources.Azure, "Azure ServiceTags JSON URL, looked up from Microsoft's download page when empty")
+	fs.Var(&c.Disabled, "disable", "comma separated built-in sources to skip: "+strings.Join(builtinSources, ", "))
+	fs.Var(&c.Feeds, "feed", "extra blocklist as LABEL=URL, may be repeated")
+}
+
+func (c *Config) applyEnv() error {
+	for key, value := range map[string]*string{
+		"IPSHIELD_LISTEN":        &c.Listen,
+		"IPSHIELD_HTTP_LISTEN":   &c.HTTPListen,
+		"IPSHIELD_PPROF_LISTEN":  &c.PprofListen,
+		"IPSHIELD_ADMIN_TOKEN":   &c.AdminToken,
+		"IPSHIELD_ZONE":          &c.Zone,
+		"IPSHIELD_ALLOWLIST":     &c.Allowlist,
+		"IPSHIELD_GEOIP_DB":      &c.GeoIPDatabase,
+		"IPSHIELD_ASN_DB":        &c.ASNDatabase,
+		"IPSHIELD_PROXY":         &c.Proxy,
+		"IPSHIELD_USER_AGENT":    &c.UserAgent,
+		"IPSHIELD_CONTACT":       &c.Contact,
+		"IPSHIELD_CACHE_DIR":     &c.CacheDir,
+		"IPSHIELD_AZURE_URL":     &c.Sources.Azure,
+		"IPSHIELD_ABUSEIPDB_KEY": &c.AbuseIPDB.APIKey,
+	} {
+		if env := os.Getenv(key); env != "" {
+			*value = env
+		}
+	}
+
+	if err := c.Disabled.Set(os.Getenv("IPSHIELD_DISABLE")); err != nil {
+		return err
+	}
+
+	for _, feed := range strings.Split(os.Getenv("IPSHIELD_FEEDS"), ",") {
+		if strings.TrimSpace(feed) == "" {
+			continue
+		}
+		if err := c.Feeds.Set(feed); err != nil {
+			return fmt.Errorf("invalid IPSHIELD_FEEDS: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Fields missing from the file keep their current value
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadConfig builds the configuration from args, usually os.Args[1:].
+// Subcommands pass extra to register flags of their own.
+func loadConfig(args []string, extra ...func(*flag.FlagSet)) (*Config, []string, error) {
+	// A first pass only finds -config, the file has to be read before the
+	// flags are applied on top of it
+	configPath := os.Getenv("IPSHIELD_CONFIG")
+	pre := flag.NewFlagSet("ipshield", flag.ContinueOnError)
+	pre.SetOutput(io.Discard)
+	pre.StringVar(&configPath, "config", configPath, "")
+	defaultConfig().registerFlags(pre)
+	for _, register := range extra {
+		register(pre)
+	}
+	if err := pre.Parse(args); err != nil && err != flag.ErrHelp {
+		return nil, nil, err
+	}
+
+	cfg := defaultConfig()
+	if configPath != "" {
+		if err := cfg.loadFile(configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs := flag.NewFlagSet("ipshield", flag.ExitOnError)
+	fs.String("config", configPath, "path to a YAML config file, also read from IPSHIELD_CONFIG")
+	cfg.registerFlags(fs)
+	for _, register := range extra {
+		register(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	for i, category := range cfg.CategoryPriority {
+		cfg.CategoryPriority[i] = strings.ToUpper(category)
+	}
+	for i, category := range cfg.BlockCategories {
+		cfg.BlockCategories[i] = strings.ToUpper(category)
+	}
+	categoryTTL := make(categoryTTLs, len(cfg.CategoryTTL))
+	for category, ttl := range cfg.CategoryTTL {
+		categoryTTL[strings.ToUpper(category)] = ttl
+	}
+	cfg.CategoryTTL = categoryTTL
+	maxEntries := make(map[string]int, len(cfg.MaxEntries))
+	for category, n := range cfg.MaxEntries {
+		maxEntries[strings.ToUpper(category)] = n
+	}
+	cfg.MaxEntries = maxEntries
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	cfg.Zone = strings.Trim(cfg.Zone, ".")
+	if cfg.Sources.Firehol == "" {
+		cfg.Sources.Firehol = fireholLevelURL(cfg.FireholLevel)
+	}
+	cfg.StatusName = strings.Trim(cfg.StatusName, ".")
+	cfg.PTRDomain = strings.Trim(cfg.PTRDomain, ".")
+	for _, source := range cfg.Disabled {
+		if !slices.Contains(builtinSources, source) {
+			return nil, nil, fmt.Errorf("unknown source %q, expected one of %s", source, strings.Join(builtinSources, ", "))
+		}
+	}
+	if err := cfg.validateFeeds(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// validateFeeds uppercases the feed labels and accepts each once. A label
+// can't reuse a built-in category or source name, the feed would share its
+// size checks, status and reload name. Labels are limited to A-Z, 0-9, _
+// and -, a : would split them as CATEGORY:source in answers.
+func (c *Config) validateFeeds() error {
+	reserved := append(slices.Clone(builtinSources), "allowlist", "geoip", "asn")
+	categories := append(slices.Clone(defaultCategoryPriority), categorySafe, categoryPrivate, categoryReserved)
+
+	for i := range c.Feeds {
+		feed := &c.Feeds[i]
+		feed.Label = strings.ToUpper(feed.Label)
+		if feed.Label == "" || feed.URL == "" {
+			return fmt.Errorf("feed %d needs both a label and a url", i+1)
+		}
+		if strings.IndexFunc(feed.Label, invalidLabelRune) >= 0 {
+			return fmt.Errorf("feed label %q may only use letters, digits, _ and -", feed.Label)
+		}
+		if slices.Contains(categories, feed.Label) || slices.Contains(reserved, feed.source()) {
+			return fmt.Errorf("feed label %q is taken by a built-in category or source", feed.Label)
+		}
+		for _, other := range c.Feeds[:i] {
+			if other.Label == feed.Label {
+				return fmt.Errorf("feed label %q used more than once", feed.Label)
+			}
+		}
+	}
+	return nil
+}
+
+func invalidLabelRune(r rune) bool {
+	return (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' && r != '-'
+}
+
+func (c *Config) validate() error {
+	for name, d := range map[string]time.Duration{
+		"cache_ttl":          c.CacheTTL,
+		"negative_cache_ttl": c.NegativeCacheTTL,
+		"update_interval":    c.UpdateInterval,
+		"download_timeout":   c.DownloadTimeout,
+		"resolver.timeout":   c.Resolver.Timeout,
+		"retry_delay":        c.RetryDelay,
+		"max_retry_delay":    c.MaxRetryDelay,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", name, d)
+		}
+	}
+	if c.RetryDelay > c.MaxRetryDelay {
+		return fmt.Errorf("retry_delay (%v) is larger than max_retry_delay (%v)", c.RetryDelay, c.MaxRetryDelay)
+	}
+	if c.FailingIntervals < 0 {
+		return fmt.Errorf("failing_intervals must not be negative, got %d", c.FailingIntervals)
+	}
+	if err := c.validateCategoryPriority(); err != nil {
+		return err
+	}
+	if err := c.validateCategoryTTL(); err != nil {
+		return err
+	}
+	if err := c.validateBlockCategories(); err != nil {
+		return err
+	}
+	if c.FireholLevel < 1 || c.FireholLevel > 3 {
+		return fmt.Errorf("firehol_level must be between 1 and 3, got %d", c.FireholLevel)
+	}
+	if c.IpsumMinScore < 1 {
+		return fmt.Errorf("ipsum_min_score must be at least 1, got %d", c.IpsumMinScore)
+	}
+	if c.MinListRatio < 0 || c.MinListRatio > 1 {
+		return fmt.Errorf("min_list_ratio must be between 0 and 1, got %v", c.MinListRatio)
+	}
+	if c.AbuseIPDB.MinConfidence < 25 || c.AbuseIPDB.MinConfidence > 100 {
+		return fmt.Errorf("abuseipdb.min_confidence must be between 25 and 100, got %d", c.AbuseIPDB.MinConfidence)
+	}
+	if c.RateLimit.ClientQPS < 0 || c.RateLimit.GlobalQPS < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+	if (c.RateLimit.ClientQPS > 0 && c.RateLimit.ClientBurst <= 0) || (c.RateLimit.GlobalQPS > 0 && c.RateLimit.GlobalBurst <= 0) {
+		return fmt.Errorf("rate limit bursts must be positive")
+	}
+	if !slices.Contains([]string{safeAnswerTXT, safeAnswerNXDomain, safeAnswerNoData}, c.SafeAnswer) {
+		return fmt.Errorf("safe_answer must be %s, %s or %s, got %q", safeAnswerTXT, safeAnswerNXDomain, safeAnswerNoData, c.SafeAnswer)
+	}
+	if c.TopQueried < 0 {
+		return fmt.Errorf("top_queried must not be negative, got %d", c.TopQueried)
+	}
+	if c.MaxBatch <= 0 {
+		return fmt.Errorf("max_batch must be positive, got %d", c.MaxBatch)
+	}
+	if len(c.AllowedASNs) > 0 && c.ASNDatabase == "" {
+		return fmt.Errorf("allowlist_asns needs an asn_database to look addresses up in")
+	}
+	if _, err := c.proxyURL(); err != nil {
+		return err
+	}
+	if c.CacheDir != "" {
+		if err := os.MkdirAll(c.CacheDir, 0o755); err != nil {
+			return fmt.Errorf("cache_dir: %w", err)
+		}
+	}
+	if c.FetchConcurrency <= 0 {
+		return fmt.Errorf("fetch_concurrency must be positive, got %d", c.FetchConcurrency)
+	}
+	if _, ok := dns.IsDomainName(c.PTRDomain); c.PTRDomain != "" && !ok {
+		return fmt.Errorf("ptr_domain %q is not a valid domain name", c.PTRDomain)
+	}
+	if err := c.validateMinEntries(); err != nil {
+		return err
+	}
+	if err := c.validateMaxEntries(); err != nil {
+		return err
+	}
+	return c.validateStaleness()
+}
+
+// knownSources names every source an update can be recorded for.
+func (c *Config) knownSources() []string {
+	known := append(slices.Clone(builtinSources), "allowlist", "geoip", "asn")
+	for _, feed := range c.Feeds {
+		known = append(known, feed.source())
+	}
+	return known
+}
+
+// validateMinEntries accepts positive counts for known sources.
+func (c *Config) validateMinEntries() error {
+	known := c.knownSources()
+	for source, n := range c.MinEntries {
+		if !slices.Contains(known, source) {
+			return fmt.Errorf("unknown source %q in min_entries, expected one of %s", source, strings.Join(known, ", "))
+		}
+		if n < 0 {
+			return fmt.Errorf("min_entries.%s must not be negative, got %d", source, n)
+		}
+	}
+	return nil
+}
+
+// validateMaxEntries accepts positive caps for the built-in categories and
+// custom feed labels.
+func (c *Config) validateMaxEntries() error {
+	known := slices.Clone(defaultCategoryPriority)
+	for _, feed := range c.Feeds {
+		known = append(known, strings.ToUpper(feed.Label))
+	}
+
+	for category, n := range c.MaxEntries {
+		if !slices.Contains(known, category) {
+			return fmt.Errorf("unknown category %q in max_entries, expected one of %s", category, strings.Join(known, ", "))
+		}
+		if n <= 0 {
+			return fmt.Errorf("max_entries.%s must be positive, got %d", category, n)
+		}
+	}
+	return nil
+}
+
+// validateStaleness only accepts maximum ages longer than the update
+// interval, otherwise healthy lists would turn stale between updates.
+func (c *Config) validateStaleness() error {
+	if c.Staleness.Policy != stalenessWarn && c.Staleness.Policy != stalenessDegrade {
+		return fmt.Errorf("staleness.policy must be %s or %s, got %q", stalenessWarn, stalenessDegrade, c.Staleness.Policy)
+	}
+
+	known := c.knownSources()
+	ages := map[string]time.Duration{"max_age": c.Staleness.MaxAge}
+	for source, maxAge := range c.Staleness.Sources {
+		if !slices.Contains(known, source) {
+			return fmt.Errorf("unknown source %q in staleness.sources, expected one of %s", source, strings.Join(known, ", "))
+		}
+		ages["sources."+source] = maxAge
+	}
+	for name, maxAge := range ages {
+		if maxAge != 0 && maxAge <= c.UpdateInterval {
+			return fmt.Errorf("staleness.%s (%v) must be 0 or longer than update_interval (%v)", name, maxAge, c.UpdateInterval)
+		}
+	}
+	return nil
+}
+
+// validateCategoryTTL accepts a TTL of one second up to a week for any
+// category an answer can start with.
+func (c *Config) validateCategoryTTL() error {
+	known := append(slices.Clone(defaultCategoryPriority), categorySafe, categoryPrivate, categoryReserved)
+	for _, feed := range c.Feeds {
+		known = append(known, strings.ToUpper(feed.Label))
+	}
+
+	for category, ttl := range c.CategoryTTL {
+		if !slices.Contains(known, category) {
+			return fmt.Errorf("unknown category %q in category_ttl, expected one of %s", category, strings.Join(known, ", "))
+		}
+		if ttl < time.Second || ttl > 7*24*time.Hour {
+			return fmt.Errorf("category_ttl of %s must be between 1s and 168h, got %v", category, ttl)
+		}
+	}
+	return nil
+}
+
+// validateCategoryPriority accepts the built-in categories and custom feed
+// labels, each at most once.
+func (c *Config) validateCategoryPriority() error {
+	known := slices.Clone(defaultCategoryPriority)
+	for _, feed := range c.Feeds {
+		known = append(known, strings.ToUpper(feed.Label))
+	}
+
+	for i, category := range c.CategoryPriority {
+		if !slices.Contains(known, category) {
+			return fmt.Errorf("unknown category %q in category_priority, expected one of %s", category, strings.Join(known, ", "))
+		}
+		if slices.Contains(c.CategoryPriority[:i], category) {
+			return fmt.Errorf("category %q listed twice in category_priority", category)
+		}
+	}
+	return nil
+}
+
+// validateBlockCategories accepts the categories that can be folded into
+// FLAGGED, built-in or custom feed labels.
+func (c *Config) validateBlockCategories() error {
+	var known []string
+	for _, category := range defaultCategoryPriority {
+		if category != categoryFlagged {
+			known = append(known, category)
+		}
+	}
+	for _, feed := range c.Feeds {
+		known = append(known, strings.ToUpper(feed.Label))
+	}
+
+	for _, category := range c.BlockCategories {
+		if !slices.Contains(known, category) {
+			return fmt.Errorf("unknown category %q in block_categories, expected one of %s", category, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// splitCategories reads a comma separated list of categories from a flag.
+func splitCategories(value string) []string {
+	var categories []string
+	for _, category := range strings.Split(value, ",") {
+		if category = strings.ToUpper(strings.TrimSpace(category)); category != "" {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
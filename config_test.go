@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFeedLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		feeds []string
+		err   string
+	}{
+		{"custom", []string{"internal=file:///a", "partner=file:///b"}, ""},
+		{"duplicate", []string{"internal=file:///a", "INTERNAL=file:///b"}, "used more than once"},
+		{"category", []string{"flagged=file:///a"}, "taken by a built-in"},
+		{"source", []string{"tor=file:///a"}, "taken by a built-in"},
+		{"private", []string{"private=file:///a"}, "taken by a built-in"},
+		{"allowlist", []string{"allowlist=file:///a"}, "taken by a built-in"},
+		{"asn", []string{"asn=file:///a"}, "taken by a built-in"},
+		{"characters", []string{"my_feed-2=file:///a"}, ""},
+		{"colon", []string{"a:b=file:///a"}, "may only use"},
+		{"dot", []string{"a.b=file:///a"}, "may only use"},
+		{"non-ascii", []string{"ünternal=file:///a"}, "may only use"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			for _, feed := range tt.feeds {
+				args = append(args, "-feed", feed)
+			}
+			_, _, err := loadConfig(args)
+			if tt.err == "" && err != nil {
+				t.Fatalf("loadConfig(%q) = %v, want no error", args, err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("loadConfig(%q) = %v, want an error containing %q", args, err, tt.err)
+			}
+		})
+	}
+}
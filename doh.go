@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+const dohMediaType = "application/dns-message"
+
+// handleDoH serves DNS over HTTPS (RFC 8484) at /dns-query, passing the
+// decoded query to the same handler as the DNS servers.
+func handleDoH(handler dns.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var packed []byte
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			if err != nil || len(packed) == 0 {
+				http.Error(w, "expected a base64url dns parameter", http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohMediaType {
+				http.Error(w, "expected "+dohMediaType, http.StatusUnsupportedMediaType)
+				return
+			}
+			var err error
+			packed, err = io.ReadAll(http.MaxBytesReader(w, r.Body, dns.MaxMsgSize))
+			if err != nil {
+				http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(packed); err != nil {
+			http.Error(w, "invalid DNS message", http.StatusBadRequest)
+			return
+		}
+
+		rw := &dohResponseWriter{remote: remoteTCPAddr(r.RemoteAddr)}
+		handler.ServeDNS(rw, query)
+		if rw.msg == nil {
+			http.Error(w, "no response", http.StatusInternalServerError)
+			return
+		}
+
+		answer, err := rw.msg.Pack()
+		if err != nil {
+			http.Error(w, "failed to pack response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", dohMediaType)
+		if ttl, ok := minTTL(rw.msg); ok {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+		}
+		w.Write(answer)
+	}
+}
+
+// minTTL returns the smallest answer TTL, which bounds how long HTTP caches
+// may keep the response.
+func minTTL(m *dns.Msg) (uint32, bool) {
+	if len(m.Answer) == 0 {
+		return 0, false
+	}
+	ttl := m.Answer[0].Header().Ttl
+	for _, rr := range m.Answer[1:] {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	return ttl, true
+}
+
+// remoteTCPAddr parses an http.Request RemoteAddr, so DoH clients are rate
+// limited by address like any other TCP client.
+func remoteTCPAddr(remote string) net.Addr {
+	addrPort, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+// dohResponseWriter captures the answer of a dns.Handler instead of sending
+// it over a DNS connection.
+type dohResponseWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// runDump implements `ipshield dump [flags]`: it loads every list once and
+// writes the merged CIDRs of the chosen categories, one per line, for
+// loading into a firewall. Single addresses are written as /32 or /128.
+//
+// The allowlist is not subtracted, the output only says what the lists
+// contain.
+func runDump(args []string, stdout io.Writer) int {
+	var output string
+	var categories sourceList
+	cfg, rest, err := loadConfig(args, func(fs *flag.FlagSet) {
+		// Registered for both passes over args, -category must not append twice
+		categories = nil
+		fs.StringVar(&output, "output", "", "file to write the CIDRs to instead of stdout")
+		fs.Var(&categories, "category", "comma separated categories to include, every category when empty")
+	})
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return 2
+	}
+	if len(rest) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: ipshield dump [-output FILE] [-category CATEGORY,...] [flags]")
+		return 2
+	}
+
+	known := dumpCategories(cfg)
+	for i, category := range categories {
+		categories[i] = strings.ToUpper(category)
+		if !slices.Contains(known, categories[i]) {
+			fmt.Fprintf(os.Stderr, "unknown category %q, expected one of %s\n", category, strings.Join(known, ", "))
+			return 2
+		}
+	}
+	if len(categories) == 0 {
+		categories = known
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	blocklists := NewBlocklists(cfg)
+	if err := blocklists.Refresh(context.Background()); err != nil && !blocklists.Loaded() {
+		slog.Error("No lists could be loaded", "error", err)
+		return 1
+	}
+
+	var networks []*net.IPNet
+	current := blocklists.snapshot()
+	for _, category := range categories {
+		networks = append(networks, current.networks(category)...)
+	}
+	networks = ip.CoalesceNetworks(networks)
+
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			slog.Error("Failed to create output file", "error", err)
+			return 1
+		}
+		defer file.Close()
+		stdout = file
+	}
+	if err := writeNetworks(stdout, networks); err != nil {
+		slog.Error("Failed to write CIDRs", "error", err)
+		return 1
+	}
+	slog.Info("Wrote CIDRs", "count", len(networks), "categories", strings.Join(categories, ","))
+	return 0
+}
+
+func writeNetworks(w io.Writer, networks []*net.IPNet) error {
+	buf := bufio.NewWriter(w)
+	for _, network := range networks {
+		fmt.Fprintln(buf, network)
+	}
+	return buf.Flush()
+}
+
+// dumpCategories lists every category that can be dumped.
+func dumpCategories(cfg *Config) []string {
+	categories := slices.Clone(defaultCategoryPriority)
+	for _, feed := range cfg.Feeds {
+		categories = append(categories, feed.Label)
+	}
+	return categories
+}
+
+// networks returns the unmerged networks reported under category.
+func (l lists) networks(category string) []*net.IPNet {
+	switch category {
+	case categoryFlagged:
+		networks := append(l.blocked.Networks(), l.drop.Networks()...)
+		for key := range l.flagged {
+			networks = append(networks, ip.HostNetwork(net.IP(key)))
+		}
+		return networks
+	case categoryDataCenter:
+		return l.dataCenter.Networks()
+	case categoryTorExit:
+		return l.torExit.Networks()
+	case categoryProxy:
+		return l.proxy.Networks()
+	case categoryCDN:
+		return l.cdn.Networks()
+	}
+	return l.custom[category].Networks()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// maxUDPSize caps the UDP payload answered with, whatever a client
+// advertises, to stay clear of IP fragmentation.
+const maxUDPSize = 1232
+
+// writeResponse sends m, sized for the transport and EDNS0 buffer of r.
+// UDP answers that don't fit the client's buffer are truncated with the TC
+// bit set, so the client retries over TCP.
+func writeResponse(w dns.ResponseWriter, r, m *dns.Msg) error {
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = min(max(int(opt.UDPSize()), dns.MinMsgSize), maxUDPSize)
+		m.SetEdns0(uint16(maxUDPSize), opt.Do())
+	}
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		size = dns.MaxMsgSize
+	}
+
+	m.Truncate(size)
+	return w.WriteMsg(m)
+}
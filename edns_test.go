@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWriteResponseSize(t *testing.T) {
+	// Four 200 byte strings take the answer well past 512 bytes
+	long := make([]string, 4)
+	for i := range long {
+		long[i] = strings.Repeat(string(rune('a'+i)), 200)
+	}
+
+	tests := []struct {
+		name      string
+		edns      uint16
+		tcp       bool
+		truncated bool
+		maxSize   int
+	}{
+		{"plain UDP", 0, false, true, dns.MinMsgSize},
+		{"EDNS0 512", 512, false, true, dns.MinMsgSize},
+		{"EDNS0 4096", 4096, false, false, maxUDPSize},
+		{"TCP", 0, true, false, dns.MaxMsgSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion("198.51.100.1.", dns.TypeTXT)
+			if tt.edns > 0 {
+				r.SetEdns0(tt.edns, false)
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: "198.51.100.1.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: long,
+			})
+
+			w := newTestResponseWriter()
+			if tt.tcp {
+				w.remote = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}
+			}
+			if err := writeResponse(w, r, m); err != nil {
+				t.Fatal(err)
+			}
+
+			packed, err := w.msg.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packed) > tt.maxSize {
+				t.Errorf("reply is %d bytes, want at most %d", len(packed), tt.maxSize)
+			}
+			if w.msg.Truncated != tt.truncated {
+				t.Errorf("TC = %v, want %v", w.msg.Truncated, tt.truncated)
+			}
+			if !tt.truncated && len(w.msg.Answer) != 1 {
+				t.Errorf("reply has %d answers, want the TXT", len(w.msg.Answer))
+			}
+			if opt := w.msg.IsEdns0(); (opt != nil) != (tt.edns > 0) {
+				t.Errorf("reply OPT = %v, want one only when the query had one", opt)
+			} else if opt != nil && opt.UDPSize() != maxUDPSize {
+				t.Errorf("reply advertises %d bytes, want %d", opt.UDPSize(), maxUDPSize)
+			}
+		})
+	}
+}
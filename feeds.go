@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// customFeed is an operator supplied netset or IP list, fetched from a URL
+// or read from disk. Matches are reported under its label instead of one of
+// the built-in categories.
+type customFeed struct {
+	Label string `yaml:"label"`
+	URL   string `yaml:"url"`
+}
+
+// feedList collects repeated -feed LABEL=URL flags.
+type feedList []customFeed
+
+func (f *feedList) String() string {
+	var feeds []string
+	for _, feed := range *f {
+		feeds = append(feeds, feed.Label+"="+feed.URL)
+	}
+	return strings.Join(feeds, ",")
+}
+
+func (f *feedList) Set(value string) error {
+	label, url, ok := strings.Cut(value, "=")
+	label, url = strings.TrimSpace(label), strings.TrimSpace(url)
+	if !ok || label == "" || url == "" {
+		return fmt.Errorf("expected LABEL=URL, got %q", value)
+	}
+
+	*f = append(*f, customFeed{Label: strings.ToUpper(label), URL: url})
+	return nil
+}
+
+func (feed customFeed) source() string {
+	return strings.ToLower(feed.Label)
+}
+
+func (feed customFeed) name() string {
+	return fmt.Sprintf("%s feed", feed.Label)
+}
+
+// storeFeed publishes the networks of feed next to the other feeds.
+func (b *Blocklists) storeFeed(feed customFeed) func(ip.IPSet, *ip.PrefixTrie) {
+	return func(_ ip.IPSet, networks *ip.PrefixTrie) {
+		// Replaced rather than modified so snapshots stay valid
+		b.swap(func(l *lists) {
+			next := maps.Clone(l.custom)
+			next[feed.Label] = networks
+			l.custom = next
+		})
+	}
+}
+
+// customFeedMatches returns the entry of every custom feed containing ip,
+// in the order the feeds were configured.
+func (l lists) customFeedMatches(ip net.IP) []overlap {
+	var matches []overlap
+	for _, feed := range l.feeds {
+		if network, ok := l.custom[feed.Label].Lookup(ip); ok {
+			matches = append(matches, overlap{feed.Label, feed.source(), network})
+		}
+	}
+	return matches
+}
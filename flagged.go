@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// Bits recording which exact-IP blocklists list an address.
+const (
+	sourceIpsum uint8 = 1 << iota
+	sourceGreensnow
+	sourceAbuseIPDB
+)
+
+// swapFlaggedIPs replaces the entries of one source with ips. The merged map
+// is rebuilt off to the side and swapped in whole, so lookups never see a
+// half updated list.
+func (b *Blocklists) swapFlaggedIPs(name string, bit uint8, ips ip.IPSet) {
+	b.flaggedBuildMu.Lock()
+	defer b.flaggedBuildMu.Unlock()
+
+	b.flaggedIPs[bit] = ips
+	next, index, shared, covered := b.rebuildFlaggedLocked(b.snapshot().blocked, bit)
+	b.swap(func(l *lists) { l.flagged, l.flaggedIndex = next, index })
+
+	slog.Info("Pruned flagged IPs", "source", strings.ToLower(name), "pruned", shared+covered,
+		"shared", shared, "firehol_covered", covered)
+}
+
+// swapFirehol replaces the Firehol networks, pruning the exact IPs against
+// them in the same swap so no lookup sees the two out of step.
+func (b *Blocklists) swapFirehol(networks *ip.PrefixTrie) {
+	b.flaggedBuildMu.Lock()
+	defer b.flaggedBuildMu.Unlock()
+
+	next, index, _, covered := b.rebuildFlaggedLocked(networks, 0)
+	b.swap(func(l *lists) {
+		l.blocked = networks
+		l.flagged, l.flaggedIndex = next, index
+	})
+
+	slog.Info("Pruned flagged IPs", "source", "firehol", "firehol_covered", covered)
+}
+
+// rebuildFlaggedLocked merges the exact-IP lists, dropping the IPs a Firehol
+// network in covering already blocks. Pruned IPs are kept in flaggedIPs, so
+// they come back if Firehol drops the network. Along with the merged map it
+// returns each list's remaining IPs in order, the count of IPs of source
+// bit also on another list, and the count of IPs covered by Firehol.
+func (b *Blocklists) rebuildFlaggedLocked(covering *ip.PrefixTrie, bit uint8) (map[string]uint8, map[uint8]ip.SortedIPs, int, int) {
+	size := 0
+	for _, ips := range b.flaggedIPs {
+		size = max(size, len(ips))
+	}
+
+	next := make(map[string]uint8, size)
+	index := make(map[uint8]ip.SortedIPs, len(b.flaggedIPs))
+	covered := make(map[string]bool)
+	for source, ips := range b.flaggedIPs {
+		kept := make(ip.IPSet, len(ips))
+		for key := range ips {
+			if covering.Contains(net.IP(key)) {
+				covered[key] = true
+				continue
+			}
+			next[key] |= source
+			kept[key] = struct{}{}
+		}
+		index[source] = ip.NewSortedIPs(kept)
+	}
+
+	shared := 0
+	for key := range b.flaggedIPs[bit] {
+		if next[key] != bit && !covered[key] {
+			shared++
+		}
+	}
+	return next, index, shared, len(covered)
+}
+
+// flaggedSourceNames names the sources set in bits.
+func flaggedSourceNames(bits uint8) []string {
+	var sources []string
+	if bits&sourceIpsum != 0 {
+		sources = append(sources, "ipsum")
+	}
+	if bits&sourceGreensnow != 0 {
+		sources = append(sources, "greensnow")
+	}
+	if bits&sourceAbuseIPDB != 0 {
+		sources = append(sources, "abuseipdb")
+	}
+	return sources
+}
+
+func (l lists) flaggedSources(ip net.IP) uint8 {
+	return l.flagged[string(ip.To16())]
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+func ipSet(addrs ...string) ip.IPSet {
+	set := make(ip.IPSet)
+	for _, addr := range addrs {
+		set.Add(net.ParseIP(addr))
+	}
+	return set
+}
+
+func mustParseCIDRs(t testing.TB, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+func TestSwapFlaggedIPsPrunes(t *testing.T) {
+	b := NewBlocklists(defaultConfig())
+	b.swapFlaggedIPs("IPsum", sourceIpsum, ipSet("1.1.1.1", "2.2.2.2"))
+	b.swapFlaggedIPs("Greensnow", sourceGreensnow, ipSet("2.2.2.2", "3.3.3.3"))
+
+	want := map[string]uint8{
+		"1.1.1.1": sourceIpsum,
+		"2.2.2.2": sourceIpsum | sourceGreensnow,
+		"3.3.3.3": sourceGreensnow,
+	}
+	check := func(want map[string]uint8) {
+		t.Helper()
+		l := b.snapshot()
+		if len(l.flagged) != len(want) {
+			t.Errorf("flagged holds %d IPs, want %d", len(l.flagged), len(want))
+		}
+		for addr, bits := range want {
+			if got := l.flaggedSources(net.ParseIP(addr)); got != bits {
+				t.Errorf("flaggedSources(%s) = %b, want %b", addr, got, bits)
+			}
+		}
+	}
+	check(want)
+
+	// Covered by Firehol, so stored once as its network
+	b.swapFirehol(ip.NewPrefixTrie(mustParseCIDRs(t, "3.3.3.0/24")))
+	check(map[string]uint8{"1.1.1.1": sourceIpsum, "2.2.2.2": sourceIpsum | sourceGreensnow})
+	if !b.IsBlocked(net.ParseIP("3.3.3.3")) {
+		t.Error("IsBlocked(3.3.3.3) = false after pruning, want true")
+	}
+
+	// Back once Firehol no longer covers it
+	b.swapFirehol(ip.NewPrefixTrie(nil))
+	check(want)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// downloadAndParseGeoIP loads a MaxMind GeoLite2 (or GeoIP2) country
+// database, used to add the country to answers. It is reloaded with the
+// lists so a replaced file is picked up.
+func (b *Blocklists) downloadAndParseGeoIP(ctx context.Context, source string) error {
+	reader, err := openMMDB(ctx, b.fetcher, source)
+	if errors.Is(err, ip.ErrNotModified) {
+		slog.Info("List unchanged since last download", "source", "geoip")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	b.swap(func(l *lists) { l.geo = reader })
+
+	slog.Info("Loaded GeoIP database", "type", reader.Metadata.DatabaseType, "built", reader.Metadata.BuildEpoch)
+	return nil
+}
+
+// openMMDB reads a whole MaxMind database into memory.
+func openMMDB(ctx context.Context, f *ip.Fetcher, source string) (*maxminddb.Reader, error) {
+	body, err := f.Open(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.FromBytes(data)
+}
+
+// country returns the ISO 3166 code of the country ip is located in, or
+// registered to, and "" when there is no database or no match.
+func (l lists) country(addr net.IP) string {
+	if l.geo == nil {
+		return ""
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	if err := l.geo.Lookup(addr, &record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	return record.RegisteredCountry.ISOCode
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+func newHTTPServer(cfg *Config, blocklists *Blocklists, dnsHandler dns.Handler, refresh []refresher) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz(blocklists))
+	mux.Handle("/lookup", requireLoaded(cfg, blocklists, handleBulkLookup(blocklists, cfg.MaxBatch)))
+	mux.Handle("/lookup/", requireLoaded(cfg, blocklists, handleLookup(blocklists)))
+	mux.Handle("/lookup-host/", requireLoaded(cfg, blocklists, handleHostLookup(cfg, blocklists)))
+	mux.HandleFunc("/dns-query", handleDoH(dnsHandler))
+	if cfg.AdminToken != "" {
+		mux.Handle("/admin/reload", requireAdminToken(cfg, handleReload(blocklists, refresh)))
+		mux.Handle("/admin/stats", requireAdminToken(cfg, handleQueryStats(blocklists)))
+	}
+
+	return &http.Server{
+		Addr:              cfg.HTTPListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// newPprofServer serves the net/http/pprof profiles on their own listener,
+// so they can be bound to localhost or firewalled apart from the API.
+func newPprofServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz only reports ready while some list has entries, otherwise
+// every lookup would come back SAFE. Under the degrade staleness policy
+// stale lists make it unready too.
+func handleReadyz(blocklists *Blocklists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !blocklists.Loaded() {
+			http.Error(w, "no blocklists loaded", http.StatusServiceUnavailable)
+			return
+		}
+		if blocklists.degraded() {
+			http.Error(w, "stale lists: "+strings.Join(blocklists.staleSources(), ", "), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// requireLoaded answers 503 instead of calling next while no list has
+// loaded, like DNS queries get SERVFAIL, unless cfg.FailOpen is set.
+func requireLoaded(cfg *Config, blocklists *Blocklists, next http.Handler) http.Handler {
+	if cfg.FailOpen {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !blocklists.Loaded() {
+			notReady.Inc()
+			http.Error(w, "no blocklists loaded yet", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminToken answers 401 instead of calling next unless the request
+// carries cfg.AdminToken as a bearer token.
+func requireAdminToken(cfg *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type lookupResponse struct {
+	IP          string   `json:"ip"`
+	Categories  []string `json:"categories"`
+	Sources     []string `json:"sources"`
+	Score       int      `json:"score"`
+	MatchedCIDR string   `json:"matched_cidr,omitempty"`
+	Country     string   `json:"country,omitempty"`
+}
+
+func newLookupResponse(ip net.IP, result classification) lookupResponse {
+	resp := lookupResponse{
+		IP:          ip.String(),
+		Categories:  result.Categories,
+		Sources:     result.Sources,
+		Score:       result.Score,
+		MatchedCIDR: result.matchedCIDR(),
+		Country:     result.Country,
+	}
+	if resp.Sources == nil {
+		resp.Sources = []string{}
+	}
+	return resp
+}
+
+type networkMatch struct {
+	Category string `json:"category"`
+	Source   string `json:"source"`
+	Network  string `json:"network"`
+}
+
+type networkLookupResponse struct {
+	Network    string         `json:"network"`
+	Categories []string       `json:"categories"`
+	Sources    []string       `json:"sources"`
+	Score      int            `json:"score"`
+	Matches    []networkMatch `json:"matches"`
+}
+
+func newNetworkLookupResponse(network *net.IPNet, result classification, matches []overlap) networkLookupResponse {
+	resp := networkLookupResponse{
+		Network:    network.String(),
+		Categories: result.Categories,
+		Sources:    result.Sources,
+		Score:      result.Score,
+		Matches:    []networkMatch{},
+	}
+	if resp.Sources == nil {
+		resp.Sources = []string{}
+	}
+	for _, match := range matches {
+		resp.Matches = append(resp.Matches, networkMatch{match.Category, match.Source, match.Network.String()})
+	}
+	return resp
+}
+
+// handleLookup serves GET /lookup/{ip} with the same classification the DNS
+// server answers with. GET /lookup/{cidr} lists the entries overlapping a
+// network instead.
+func handleLookup(blocklists *Blocklists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := strings.TrimPrefix(r.URL.Path, "/lookup/")
+		if strings.Contains(query, "/") {
+			network, ok := parseQueryCIDR(query)
+			if !ok {
+				http.Error(w, "invalid CIDR", http.StatusBadRequest)
+				return
+			}
+			result, matches := blocklists.Overlaps(network)
+			writeJSON(w, http.StatusOK, newNetworkLookupResponse(network, result, matches))
+			return
+		}
+
+		addr := ip.Canonical(net.ParseIP(query))
+		if addr == nil {
+			http.Error(w, "invalid IP address", http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newLookupResponse(addr, blocklists.Classify(addr)))
+	}
+}
+
+type lookupError struct {
+	IP    string `json:"ip"`
+	Error string `json:"error"`
+}
+
+// handleBulkLookup serves POST /lookup, classifying a JSON array of IPs and
+// answering with results in the same order. Entries that aren't valid IPs
+// get an error instead of failing the whole batch. maxBatch caps how many IPs
+// a single request may carry.
+func handleBulkLookup(blocklists *Blocklists, maxBatch int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Generous enough for maxBatch IPv6 addresses
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBatch)*64+2)
+
+		var addrs []string
+		if err := json.NewDecoder(r.Body).Decode(&addrs); err != nil {
+			http.Error(w, "expected a JSON array of IP addresses", http.StatusBadRequest)
+			return
+		}
+		if len(addrs) > maxBatch {
+			http.Error(w, fmt.Sprintf("at most %d IPs per request", maxBatch), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		results := make([]any, len(addrs))
+
+		current := blocklists.snapshot()
+		for i, addr := range addrs {
+			parsed := ip.Canonical(net.ParseIP(strings.TrimSpace(addr)))
+			if parsed == nil {
+				results[i] = lookupError{IP: addr, Error: "invalid IP address"}
+				continue
+			}
+			results[i] = newLookupResponse(parsed, current.classify(parsed))
+		}
+
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+type hostLookupResponse struct {
+	Host    string `json:"host"`
+	Results []any  `json:"results"`
+}
+
+// handleHostLookup serves GET /lookup-host/{name}, resolving name to its A
+// and AAAA records and classifying each address like /lookup. A name that
+// resolves to private or reserved addresses could be used to probe the
+// network ipshield runs in, so those get an error entry unless
+// cfg.Resolver.AllowPrivate is set.
+func handleHostLookup(cfg *Config, blocklists *Blocklists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		host := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/lookup-host/"), ".")
+		if _, ok := dns.IsDomainName(host); !ok || host == "" || net.ParseIP(host) != nil {
+			http.Error(w, "invalid host name, use /lookup/ for IP addresses", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Resolver.Timeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			var dnsErr *net.DNSError
+			switch {
+			case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+				http.Error(w, "host not found", http.StatusNotFound)
+			case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+				http.Error(w, "timed out resolving host", http.StatusGatewayTimeout)
+			default:
+				http.Error(w, "failed to resolve host", http.StatusBadGateway)
+			}
+			return
+		}
+
+		resp := hostLookupResponse{Host: host, Results: make([]any, 0, len(addrs))}
+		current := blocklists.snapshot()
+		for _, addr := range addrs {
+			parsed := ip.Canonical(addr.IP)
+			if !cfg.Resolver.AllowPrivate && (parsed.IsPrivate() || ip.IsReserved(parsed)) {
+				resp.Results = append(resp.Results, lookupError{IP: parsed.String(), Error: "private or reserved address, not looked up"})
+				continue
+			}
+			resp.Results = append(resp.Results, newLookupResponse(parsed, current.classify(parsed)))
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,69 @@
+package ip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// AbuseIPDBBlacklistURL is the default blacklist endpoint.
+const AbuseIPDBBlacklistURL = "https://api.abuseipdb.com/api/v2/blacklist"
+
+// GetAbuseIPDBBlacklist downloads the AbuseIPDB blacklist from endpoint,
+// normally AbuseIPDBBlacklistURL, keeping the IPs reported with at least
+// minConfidence (25-100) percent confidence.
+func (f *Fetcher) GetAbuseIPDBBlacklist(ctx context.Context, endpoint, apiKey string, minConfidence int) (IPSet, error) {
+	// API answers are never cached on disk
+	if cacheOnly(ctx) {
+		return nil, fmt.Errorf("AbuseIPDB blacklist: %w", ErrNotCached)
+	}
+
+	query := url.Values{"confidenceMinimum": {strconv.Itoa(minConfidence)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Key", apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := f.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch AbuseIPDB blacklist: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// The error body explains bad keys and exhausted quotas
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("AbuseIPDB answered %s: %s", resp.Status, detail)
+	}
+
+	ips := make(IPSet)
+	err = decodeJSONArrays(resp.Body, map[string]func(*json.Decoder) error{
+		"data": func(dec *json.Decoder) error {
+			var entry struct {
+				IPAddress            string `json:"ipAddress"`
+				AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
+			}
+			if err := dec.Decode(&entry); err != nil {
+				return err
+			}
+			if entry.AbuseConfidenceScore < minConfidence {
+				return nil
+			}
+			if addr := net.ParseIP(entry.IPAddress); addr != nil {
+				ips.Add(addr)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode AbuseIPDB blacklist: %w", err)
+	}
+	return ips, nil
+}
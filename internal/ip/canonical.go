@@ -0,0 +1,46 @@
+package ip
+
+import "net"
+
+// Canonical returns ip in the form lists are stored and queried in: 4 bytes
+// for IPv4, including IPv4-mapped IPv6 addresses, 16 bytes otherwise. It
+// returns nil for anything that isn't an IP.
+func Canonical(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// CanonicalNetwork is Canonical for networks, so an IPv4-mapped prefix such
+// as ::ffff:192.0.2.0/120 becomes 192.0.2.0/24. It returns nil for
+// networks with a non-contiguous mask.
+func CanonicalNetwork(network *net.IPNet) *net.IPNet {
+	if network == nil {
+		return nil
+	}
+
+	ones, bits := network.Mask.Size()
+	switch {
+	case bits == 8*net.IPv4len:
+		if v4 := network.IP.To4(); v4 != nil {
+			return &net.IPNet{IP: v4.Mask(network.Mask), Mask: network.Mask}
+		}
+	case bits == 8*net.IPv6len:
+		if v4 := network.IP.To4(); v4 != nil && ones >= 96 {
+			mask := net.CIDRMask(ones-96, 8*net.IPv4len)
+			return &net.IPNet{IP: v4.Mask(mask), Mask: mask}
+		}
+		if v6 := network.IP.To16(); v6 != nil {
+			return &net.IPNet{IP: v6.Mask(network.Mask), Mask: network.Mask}
+		}
+	}
+	return nil
+}
+
+// HostNetwork returns the single address network of addr in canonical form,
+// /32 for IPv4 in either form and /128 for IPv6.
+func HostNetwork(addr net.IP) *net.IPNet {
+	addr = Canonical(addr)
+	return &net.IPNet{IP: addr, Mask: net.CIDRMask(8*len(addr), 8*len(addr))}
+}
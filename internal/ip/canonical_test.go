@@ -0,0 +1,114 @@
+package ip
+
+import (
+	"net"
+	"testing"
+)
+
+// addrForms returns the 4-byte, 16-byte and IPv4-mapped forms of addr.
+func addrForms(addr string) []net.IP {
+	v16 := net.ParseIP(addr)
+	return []net.IP{v16.To4(), v16, net.ParseIP("::ffff:" + addr)}
+}
+
+func TestCanonical(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4", "0.0.0.0", "255.255.255.255"} {
+		for _, form := range addrForms(addr) {
+			got := Canonical(form)
+			if len(got) != net.IPv4len || got.String() != addr {
+				t.Errorf("Canonical(%d byte %s) = %d byte %s, want 4 byte %s", len(form), form, len(got), got, addr)
+			}
+		}
+	}
+
+	if got := Canonical(net.ParseIP("2001:db8::1")); len(got) != net.IPv6len {
+		t.Errorf("Canonical(2001:db8::1) is %d bytes, want 16", len(got))
+	}
+	if got := Canonical(net.IP{1, 2, 3}); got != nil {
+		t.Errorf("Canonical(3 bytes) = %v, want nil", got)
+	}
+}
+
+func TestCanonicalNetwork(t *testing.T) {
+	tests := []struct {
+		network *net.IPNet
+		want    string
+	}{
+		{&net.IPNet{IP: net.IP{192, 0, 2, 9}, Mask: net.CIDRMask(24, 32)}, "192.0.2.0/24"},
+		{&net.IPNet{IP: net.ParseIP("192.0.2.9"), Mask: net.CIDRMask(24, 32)}, "192.0.2.0/24"},
+		{&net.IPNet{IP: net.ParseIP("::ffff:192.0.2.9"), Mask: net.CIDRMask(120, 128)}, "192.0.2.0/24"},
+		{&net.IPNet{IP: net.ParseIP("::ffff:0:0"), Mask: net.CIDRMask(96, 128)}, "0.0.0.0/0"},
+		{&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(32, 128)}, "2001:db8::/32"},
+		// Too short to be IPv4-mapped, so it stays IPv6
+		{&net.IPNet{IP: net.ParseIP("::ffff:0:0"), Mask: net.CIDRMask(64, 128)}, "::/64"},
+	}
+	for _, tt := range tests {
+		got := CanonicalNetwork(tt.network)
+		if got == nil || got.String() != tt.want {
+			t.Errorf("CanonicalNetwork(%v) = %v, want %s", tt.network, got, tt.want)
+			continue
+		}
+		if ones, bits := got.Mask.Size(); bits != 8*len(got.IP) {
+			t.Errorf("CanonicalNetwork(%v) has a /%d of %d bits for a %d byte address", tt.network, ones, bits, len(got.IP))
+		}
+	}
+
+	if got := CanonicalNetwork(&net.IPNet{IP: net.IP{1, 2, 3, 4}, Mask: net.IPMask{255, 0, 255, 0}}); got != nil {
+		t.Errorf("CanonicalNetwork(non-contiguous mask) = %v, want nil", got)
+	}
+}
+
+// TestAddressForms checks that every form of an IPv4 address, and of the
+// networks holding it, is the same member of a PrefixTrie and an IPSet.
+func TestHostNetwork(t *testing.T) {
+	tests := []struct {
+		addr net.IP
+		want string
+	}{
+		{net.IP{192, 0, 2, 1}, "192.0.2.1/32"},
+		{net.ParseIP("192.0.2.1"), "192.0.2.1/32"},
+		{net.ParseIP("::ffff:192.0.2.1"), "192.0.2.1/32"},
+		{net.ParseIP("2001:db8::1"), "2001:db8::1/128"},
+	}
+	for _, tt := range tests {
+		got := HostNetwork(tt.addr)
+		if ones, bits := got.Mask.Size(); got.String() != tt.want || ones != bits || bits != 8*len(got.IP) {
+			t.Errorf("HostNetwork(%v) = %v with a /%d of %d bits, want %s", tt.addr, got, ones, bits, tt.want)
+		}
+	}
+}
+
+func TestAddressForms(t *testing.T) {
+	networks := []*net.IPNet{
+		{IP: net.IP{198, 51, 100, 0}, Mask: net.CIDRMask(24, 32)},
+		{IP: net.ParseIP("203.0.113.0"), Mask: net.CIDRMask(24, 32)},
+		{IP: net.ParseIP("::ffff:192.0.2.0"), Mask: net.CIDRMask(120, 128)},
+	}
+	trie := NewPrefixTrie(networks)
+	if trie.Len() != len(networks) {
+		t.Fatalf("trie holds %d networks, want %d", trie.Len(), len(networks))
+	}
+
+	for _, addr := range []string{"198.51.100.7", "203.0.113.7", "192.0.2.7"} {
+		set := make(IPSet)
+		set.Add(net.ParseIP(addr).To4())
+		for _, form := range addrForms(addr) {
+			network, ok := trie.Lookup(form)
+			if !ok || len(network.IP) != net.IPv4len {
+				t.Errorf("Lookup(%d byte %s) = %v, %v, want its 4 byte /24", len(form), form, network, ok)
+			}
+			if !set.Contains(form) {
+				t.Errorf("IPSet.Contains(%d byte %s) = false, want true", len(form), form)
+			}
+		}
+	}
+
+	// The same address added in every form is one member
+	set := make(IPSet)
+	for _, form := range addrForms("192.0.2.1") {
+		set.Add(form)
+	}
+	if len(set) != 1 {
+		t.Errorf("IPSet holds %d members for one address, want 1", len(set))
+	}
+}
@@ -0,0 +1,35 @@
+package ip
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// GetCDNIPRanges returns the published ranges of CDNs and reverse proxies.
+// Traffic from these is proxied for someone else, so it is reported apart
+// from data center ranges.
+func (f *Fetcher) GetCDNIPRanges(ctx context.Context) ([]*net.IPNet, error) {
+	var allRanges []*net.IPNet
+	for _, url := range []string{f.Ranges.CloudflareIPv4, f.Ranges.CloudflareIPv6} {
+		ranges, err := f.withLastRanges(ctx, url, func(ctx context.Context) ([]*net.IPNet, error) {
+			return f.getCloudflareRanges(ctx, url)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Cloudflare: %w", err)
+		}
+		allRanges = append(allRanges, ranges...)
+	}
+
+	return CoalesceNetworks(allRanges), nil
+}
+
+func (f *Fetcher) getCloudflareRanges(ctx context.Context, url string) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Cloudflare IP ranges: %w", err)
+	}
+	defer body.Close()
+
+	return parseIPRanges(body, ParseStatsFrom(ctx))
+}
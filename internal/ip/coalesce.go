@@ -0,0 +1,148 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+type addrRange struct {
+	start, end netip.Addr
+}
+
+// CoalesceNetworks merges overlapping and adjacent networks into the
+// smallest equivalent set of CIDRs. IPv4 and IPv6 are merged separately and
+// the result is sorted, IPv4 first.
+func CoalesceNetworks(networks []*net.IPNet) []*net.IPNet {
+	var ranges []addrRange
+	for _, network := range networks {
+		prefix, ok := toPrefix(network)
+		if !ok {
+			continue
+		}
+		ranges = append(ranges, addrRange{prefix.Addr(), lastAddr(prefix)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Less(ranges[j].start)
+	})
+
+	var merged []addrRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && canMerge(merged[n-1], r) {
+			if merged[n-1].end.Less(r.end) {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	var result []*net.IPNet
+	for _, r := range merged {
+		result = append(result, rangeToNetworks(r.start, r.end)...)
+	}
+	return result
+}
+
+// canMerge reports whether b, which starts no earlier than a, overlaps or
+// directly follows a within the same address family.
+func canMerge(a, b addrRange) bool {
+	if a.start.Is4() != b.start.Is4() {
+		return false
+	}
+	next := a.end.Next()
+	return !next.IsValid() || !next.Less(b.start)
+}
+
+// ParseRange parses an inclusive range written as start-end, such as
+// 1.2.3.0-1.2.3.255, into the minimal CIDRs covering it.
+func ParseRange(s string) ([]*net.IPNet, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q, expected start-end", s)
+	}
+	start, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+	end, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+
+	if start.Zone() != "" || end.Zone() != "" {
+		return nil, fmt.Errorf("invalid range %q, addresses can't have a zone", s)
+	}
+
+	start, end = start.Unmap(), end.Unmap()
+	if start.Is4() != end.Is4() || end.Less(start) {
+		return nil, fmt.Errorf("invalid range %q, start and end must be one family in order", s)
+	}
+	return rangeToNetworks(start, end), nil
+}
+
+// rangeToNetworks returns the minimal CIDRs covering start through end.
+func rangeToNetworks(start, end netip.Addr) []*net.IPNet {
+	var networks []*net.IPNet
+	for {
+		bits := start.BitLen()
+		for bits > 0 {
+			wider := netip.PrefixFrom(start, bits-1).Masked()
+			if wider.Addr() != start || end.Less(lastAddr(wider)) {
+				break
+			}
+			bits--
+		}
+
+		prefix := netip.PrefixFrom(start, bits)
+		networks = append(networks, fromPrefix(prefix))
+
+		last := lastAddr(prefix)
+		if !last.Less(end) {
+			return networks
+		}
+		start = last.Next()
+	}
+}
+
+func toPrefix(network *net.IPNet) (netip.Prefix, bool) {
+	network = CanonicalNetwork(network)
+	if network == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	ones, _ := network.Mask.Size()
+	return netip.PrefixFrom(addr, ones), true
+}
+
+func fromPrefix(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   net.IP(prefix.Addr().AsSlice()),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
+
+// lastAddr returns the highest address inside prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().As16()
+	offset := 0
+	if prefix.Addr().Is4() {
+		offset = 96
+	}
+	for i := offset + prefix.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+
+	addr := netip.AddrFrom16(b)
+	if prefix.Addr().Is4() {
+		addr = addr.Unmap()
+	}
+	return addr
+}
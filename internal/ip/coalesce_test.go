@@ -0,0 +1,115 @@
+package ip
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func networkStrings(networks []*net.IPNet) []string {
+	s := make([]string, len(networks))
+	for i, network := range networks {
+		s[i] = network.String()
+	}
+	return s
+}
+
+func TestCoalesceNetworks(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{
+			name: "v6 contained",
+			in:   []string{"2600:1f00::/24", "2600:1f14:8000::/36", "2600:1f14::/32"},
+			want: []string{"2600:1f00::/24"},
+		},
+		{
+			name: "v6 adjacent halves",
+			in:   []string{"2a05:d018::/33", "2a05:d018:8000::/33"},
+			want: []string{"2a05:d018::/32"},
+		},
+		{
+			name: "v6 adjacent, not aligned",
+			in:   []string{"2a05:d019::/32", "2a05:d01a::/32"},
+			want: []string{"2a05:d019::/32", "2a05:d01a::/32"},
+		},
+		{
+			name: "v6 partial overlap",
+			in:   []string{"2001:4860::/33", "2001:4860:4000::/34", "2001:4860:8000::/33"},
+			want: []string{"2001:4860::/32"},
+		},
+		{
+			name: "v6 gap",
+			in:   []string{"2a01:4f8::/32", "2a01:4fa::/32"},
+			want: []string{"2a01:4f8::/32", "2a01:4fa::/32"},
+		},
+		{
+			name: "v6 top of the space",
+			in:   []string{"ffff:ffff:ffff:ffff::/65", "ffff:ffff:ffff:ffff:8000::/65"},
+			want: []string{"ffff:ffff:ffff:ffff::/64"},
+		},
+		{
+			name: "v6 bottom of the space",
+			in:   []string{"::/1", "8000::/1"},
+			want: []string{"::/0"},
+		},
+		{
+			name: "families stay apart",
+			in:   []string{"::/96", "0.0.0.0/1", "128.0.0.0/1", "::1:0:0/96"},
+			want: []string{"0.0.0.0/0", "::/95"},
+		},
+		{
+			name: "v4-mapped merges as v4",
+			in:   []string{"::ffff:10.0.0.0/121", "10.0.0.128/25"},
+			want: []string{"10.0.0.0/24"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in []*net.IPNet
+			for _, s := range tt.in {
+				_, network, err := net.ParseCIDR(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				in = append(in, network)
+			}
+			if got := networkStrings(CoalesceNetworks(in)); !slices.Equal(got, tt.want) {
+				t.Errorf("CoalesceNetworks(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "1.2.3.0-1.2.3.255", want: []string{"1.2.3.0/24"}},
+		{in: " 1.2.3.4 - 1.2.3.4 ", want: []string{"1.2.3.4/32"}},
+		{in: "1.2.3.1-1.2.3.6", want: []string{"1.2.3.1/32", "1.2.3.2/31", "1.2.3.4/31", "1.2.3.6/32"}},
+		{in: "::ffff:10.0.0.0-10.0.0.255", want: []string{"10.0.0.0/24"}},
+		{in: "2001:db8::-2001:db8::ffff", want: []string{"2001:db8::/112"}},
+		{in: "fe80::1-fe80::2", want: []string{"fe80::1/128", "fe80::2/128"}},
+		{in: "fe80::1%eth0-fe80::2%eth0", wantErr: true},
+		{in: "fe80::1-fe80::2%eth0", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+		{in: "1.2.3.4-1.2.3.0", wantErr: true},
+		{in: "1.2.3.4-2001:db8::1", wantErr: true},
+		{in: "1.2.3.4-1.2.3.256", wantErr: true},
+	}
+	for _, tt := range tests {
+		networks, err := ParseRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got := networkStrings(networks); !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseRange(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
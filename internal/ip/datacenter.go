@@ -1,23 +1,63 @@
 package ip
 
 import (
-	"bufio"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
-	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 )
 
-const (
-	datacenterIPRangesURL = "https://raw.githubusercontent.com/jhassine/server-ip-addresses/master/data/datacenters.txt"
-	ociCIDRURL            = "https://docs.cloud.oracle.com/en-us/iaas/tools/public_ip_ranges.json"
-	doCIDRURL             = "https://www.digitalocean.com/geo/google.csv"
-	vultrCIDRURL          = "https://geofeed.constant.com/?text"
-)
+// RangeURLs locates the published ranges of every data center provider and
+// CDN. Mirrors and tests may point these elsewhere, and like any source
+// they may be file:// URLs or paths.
+type RangeURLs struct {
+	Datacenter        string `yaml:"datacenter"`
+	OCI               string `yaml:"oci"`
+	DigitalOcean      string `yaml:"digitalocean"`
+	Vultr             string `yaml:"vultr"`
+	AWS               string `yaml:"aws"`
+	GCP               string `yaml:"gcp"`
+	AzureDownloadPage string `yaml:"azure_download_page"`
+	// AzureServiceTags pins the Azure ServiceTags JSON to download. When
+	// empty the current file is looked up from AzureDownloadPage, whose
+	// link changes every week. It's configured with the list sources.
+	AzureServiceTags string `yaml:"-"`
+	CloudflareIPv4   string `yaml:"cloudflare_v4"`
+	CloudflareIPv6   string `yaml:"cloudflare_v6"`
+
+	// Akamai and Scaleway don't publish a machine readable list, the
+	// built-in AKAMAI_CIDR and SCALEWAY_CIDR are used unless these point
+	// at one CIDR per line
+	Akamai   string `yaml:"akamai"`
+	Scaleway string `yaml:"scaleway"`
+	// ExtendBuiltin adds the ranges read from Akamai and Scaleway to the
+	// built-in ones instead of replacing them
+	ExtendBuiltin bool `yaml:"extend_builtin"`
+}
+
+// DefaultRangeURLs returns where each provider publishes its ranges.
+func DefaultRangeURLs() RangeURLs {
+	return RangeURLs{
+		Datacenter:        "https://raw.githubusercontent.com/jhassine/server-ip-addresses/master/data/datacenters.txt",
+		OCI:               "https://docs.cloud.oracle.com/en-us/iaas/tools/public_ip_ranges.json",
+		DigitalOcean:      "https://www.digitalocean.com/geo/google.csv",
+		Vultr:             "https://geofeed.constant.com/?text",
+		AWS:               "https://ip-ranges.amazonaws.com/ip-ranges.json",
+		GCP:               "https://www.gstatic.com/ipranges/cloud.json",
+		AzureDownloadPage: "https://www.microsoft.com/en-us/download/details.aspx?id=56519",
+		CloudflareIPv4:    "https://www.cloudflare.com/ips-v4",
+		CloudflareIPv6:    "https://www.cloudflare.com/ips-v6",
+	}
+}
+
+var azureServiceTagsLink = regexp.MustCompile(`https://download\.microsoft\.com/download/[^"'\s]+/ServiceTags_Public_\d+\.json`)
 
 var (
 	// https://techdocs.akamai.com/origin-ip-acl/docs/update-your-origin-server
@@ -37,11 +77,75 @@ var (
 	}
 )
 
-func GetDataCenterIPRanges() ([]*net.IPNet, error) {
+type dataCenterProvider struct {
+	name  string
+	fetch func(context.Context) ([]*net.IPNet, error)
+}
+
+func (f *Fetcher) dataCenterProviders() []dataCenterProvider {
+	return []dataCenterProvider{
+		{"main datacenter ranges", f.getMainDatacenterRanges},
+		{"OCI", f.getOCIRanges},
+		{"DigitalOcean", f.getDORanges},
+		{"Vultr", f.getVultrRanges},
+		{"AWS", f.getAWSRanges},
+		{"GCP", f.getGCPRanges},
+		{"Azure", f.getAzureRanges},
+		{"Akamai", f.staticRanges(AKAMAI_CIDR, f.Ranges.Akamai)},
+		{"Scaleway", f.staticRanges(SCALEWAY_CIDR, f.Ranges.Scaleway)},
+	}
+}
+
+// staticRanges returns the ranges listed at source, or the built-in ones
+// when source is empty or can't be read, so a broken override never leaves
+// the provider out.
+func (f *Fetcher) staticRanges(builtin []string, source string) func(context.Context) ([]*net.IPNet, error) {
+	return func(ctx context.Context) ([]*net.IPNet, error) {
+		builtinRanges := parseCIDRSlice(builtin, nil)
+		if source == "" {
+			return builtinRanges, nil
+		}
+
+		body, err := f.Open(ctx, source)
+		if errors.Is(err, ErrNotModified) {
+			return nil, err
+		}
+		var ranges []*net.IPNet
+		if err == nil {
+			defer body.Close()
+			ranges, err = parseIPRanges(body, ParseStatsFrom(ctx))
+		}
+		if err == nil && len(ranges) == 0 {
+			err = errors.New("no valid CIDRs")
+		}
+		if err != nil {
+			slog.Warn("Falling back to built-in ranges", "source", redactURL(source), "error", err)
+			return builtinRanges, nil
+		}
+
+		if f.Ranges.ExtendBuiltin {
+			ranges = append(ranges, builtinRanges...)
+		}
+		return ranges, nil
+	}
+}
+
+// GetDataCenterIPRanges downloads every provider's ranges concurrently and
+// returns them coalesced. However the downloads interleave, the result is
+// the same for the same input: sorted by address, IPv4 before IPv6, with no
+// two networks overlapping. Providers that fail are reported in the error
+// alongside the ranges of the others.
+func (f *Fetcher) GetDataCenterIPRanges(ctx context.Context) ([]*net.IPNet, error) {
 	var allRanges []*net.IPNet
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	errChan := make(chan error, 3) // Buffer for potential errors
+
+	// Some provider lists are large, downloading them all at once spikes
+	// memory and upsets rate limits
+	sem := make(chan struct{}, max(f.DataCenterConcurrency, 1))
+
+	providers := f.dataCenterProviders()
+	errChan := make(chan error, len(providers))
 
 	// Helper function to add IP ranges
 	addRanges := func(ranges []*net.IPNet) {
@@ -50,165 +154,259 @@ func GetDataCenterIPRanges() ([]*net.IPNet, error) {
 		mu.Unlock()
 	}
 
-	// Main datacenter ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := getMainDatacenterRanges()
-		if err != nil {
-			errChan <- fmt.Errorf("main datacenter ranges: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
-
-	// OCI ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := getOCIRanges()
-		if err != nil {
-			errChan <- fmt.Errorf("OCI: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
-
-	// DigitalOcean ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := getDORanges()
-		if err != nil {
-			errChan <- fmt.Errorf("DigitalOcean: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
-
-	// Vultr ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := getVultrRanges()
-		if err != nil {
-			errChan <- fmt.Errorf("Vultr: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
-
-	// Akamai ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := parseIPRanges(strings.NewReader(strings.Join(AKAMAI_CIDR, "\n")))
-		if err != nil {
-			errChan <- fmt.Errorf("Akamai: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
-
-	// Scaleway ranges
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ranges, err := parseIPRanges(strings.NewReader(strings.Join(SCALEWAY_CIDR, "\n")))
-		if err != nil {
-			errChan <- fmt.Errorf("Scaleway: %w", err)
-			return
-		}
-		addRanges(ranges)
-	}()
+	for _, provider := range providers {
+		wg.Add(1)
+		go func(provider dataCenterProvider) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ranges, err := f.withLastRanges(ctx, provider.name, provider.fetch)
+			if err != nil {
+				errChan <- fmt.Errorf("%s: %w", provider.name, err)
+				return
+			}
+			addRanges(ranges)
+		}(provider)
+	}
 
 	wg.Wait()
 	close(errChan)
 
-	// Collect any errors
-	var errStrings []string
+	// Providers overlap a lot, so store the minimal covering set. This also
+	// undoes the arbitrary order the goroutines appended in
+	allRanges = CoalesceNetworks(allRanges)
+
+	// Collect any errors, still wrapped so callers can match them
+	var errs []any
 	for err := range errChan {
-		errStrings = append(errStrings, err.Error())
+		errs = append(errs, err)
 	}
 
-	if len(errStrings) > 0 {
-		return allRanges, fmt.Errorf("errors occurred: %s", strings.Join(errStrings, "; "))
+	if len(errs) > 0 {
+		format := "errors occurred: " + strings.TrimSuffix(strings.Repeat("%w; ", len(errs)), "; ")
+		return allRanges, fmt.Errorf(format, errs...)
 	}
 
 	return allRanges, nil
 }
 
-func getMainDatacenterRanges() ([]*net.IPNet, error) {
-	resp, err := http.Get(datacenterIPRangesURL)
+// withLastRanges calls fetch and remembers its result under name, handing
+// back the previous ranges when the source reports it hasn't changed.
+func (f *Fetcher) withLastRanges(ctx context.Context, name string, fetch func(context.Context) ([]*net.IPNet, error)) ([]*net.IPNet, error) {
+	ranges, err := fetch(ctx)
+	if errors.Is(err, ErrNotModified) {
+		f.lastRangesMu.Lock()
+		defer f.lastRangesMu.Unlock()
+		return f.lastRanges[name], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f.lastRangesMu.Lock()
+	if f.lastRanges == nil {
+		f.lastRanges = make(map[string][]*net.IPNet)
+	}
+	f.lastRanges[name] = ranges
+	f.lastRangesMu.Unlock()
+	return ranges, nil
+}
+
+func (f *Fetcher) getMainDatacenterRanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.Datacenter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch main datacenter IP ranges: %w", err)
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
-	return parseIPRanges(resp.Body)
+	return parseIPRanges(body, ParseStatsFrom(ctx))
 }
 
-func getVultrRanges() ([]*net.IPNet, error) {
-	resp, err := http.Get(vultrCIDRURL)
+func (f *Fetcher) getAWSRanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.AWS)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch Vultr IP ranges: %w", err)
+		return nil, fmt.Errorf("failed to fetch AWS IP ranges: %w", err)
+	}
+	defer body.Close()
+
+	stats := ParseStatsFrom(ctx)
+	var ranges []*net.IPNet
+	err = decodeJSONArrays(body, map[string]func(*json.Decoder) error{
+		"prefixes": func(dec *json.Decoder) error {
+			var prefix struct {
+				IPPrefix string `json:"ip_prefix"`
+			}
+			if err := dec.Decode(&prefix); err != nil {
+				return err
+			}
+			ranges = appendIPRange(ranges, prefix.IPPrefix, stats)
+			return nil
+		},
+		"ipv6_prefixes": func(dec *json.Decoder) error {
+			var prefix struct {
+				IPv6Prefix string `json:"ipv6_prefix"`
+			}
+			if err := dec.Decode(&prefix); err != nil {
+				return err
+			}
+			ranges = appendIPRange(ranges, prefix.IPv6Prefix, stats)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AWS IP ranges JSON: %w", err)
 	}
-	defer resp.Body.Close()
+	return ranges, nil
+}
 
-	var ranges []string
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			ranges = append(ranges, line)
-		}
+func (f *Fetcher) getGCPRanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.GCP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch GCP IP ranges: %w", err)
+	}
+	defer body.Close()
+
+	stats := ParseStatsFrom(ctx)
+	var ranges []*net.IPNet
+	err = decodeJSONArrays(body, map[string]func(*json.Decoder) error{
+		"prefixes": func(dec *json.Decoder) error {
+			var prefix struct {
+				IPv4Prefix string `json:"ipv4Prefix"`
+				IPv6Prefix string `json:"ipv6Prefix"`
+			}
+			if err := dec.Decode(&prefix); err != nil {
+				return err
+			}
+			// Each entry carries one of the two prefixes
+			if prefix.IPv4Prefix != "" {
+				ranges = appendIPRange(ranges, prefix.IPv4Prefix, stats)
+			}
+			if prefix.IPv6Prefix != "" {
+				ranges = appendIPRange(ranges, prefix.IPv6Prefix, stats)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GCP IP ranges JSON: %w", err)
 	}
+	return ranges, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading Vultr IP ranges: %w", err)
+func (f *Fetcher) resolveAzureServiceTagsURL(ctx context.Context) (string, error) {
+	if f.Ranges.AzureServiceTags != "" {
+		return f.Ranges.AzureServiceTags, nil
+	}
+
+	body, err := f.Open(ctx, f.Ranges.AzureDownloadPage)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch Azure download page: %w", err)
+	}
+	defer body.Close()
+
+	page, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("error reading Azure download page: %w", err)
 	}
 
-	return parseIPRanges(strings.NewReader(strings.Join(ranges, "\n")))
+	link := azureServiceTagsLink.Find(page)
+	if link == nil {
+		return "", errors.New("no ServiceTags download link on Azure download page")
+	}
+	return string(link), nil
 }
 
-func getOCIRanges() ([]*net.IPNet, error) {
-	resp, err := http.Get(ociCIDRURL)
+func (f *Fetcher) getAzureRanges(ctx context.Context) ([]*net.IPNet, error) {
+	serviceTagsURL, err := f.resolveAzureServiceTagsURL(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch OCI IP ranges: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var data struct {
-		Regions []struct {
-			Region string `json:"region"`
-			Cidrs  []struct {
-				Cidr string `json:"cidr"`
-			} `json:"cidrs"`
-		} `json:"regions"`
+	body, err := f.Open(ctx, serviceTagsURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Azure IP ranges: %w", err)
 	}
+	defer body.Close()
+
+	// Service tags overlap heavily, the coalescing pass cleans that up
+	stats := ParseStatsFrom(ctx)
+	var ranges []*net.IPNet
+	err = decodeJSONArrays(body, map[string]func(*json.Decoder) error{
+		"values": func(dec *json.Decoder) error {
+			var value struct {
+				Properties struct {
+					AddressPrefixes []string `json:"addressPrefixes"`
+				} `json:"properties"`
+			}
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			for _, prefix := range value.Properties.AddressPrefixes {
+				ranges = appendIPRange(ranges, prefix, stats)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Azure IP ranges JSON: %w", err)
+	}
+	return ranges, nil
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, fmt.Errorf("failed to parse OCI IP ranges JSON: %w", err)
+func (f *Fetcher) getVultrRanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.Vultr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Vultr IP ranges: %w", err)
 	}
+	defer body.Close()
 
-	var ranges []string
-	for _, region := range data.Regions {
-		for _, cidr := range region.Cidrs {
-			ranges = append(ranges, cidr.Cidr)
-		}
+	ranges, err := parseIPRanges(body, ParseStatsFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading Vultr IP ranges: %w", err)
 	}
+	return ranges, nil
+}
 
-	return parseIPRanges(strings.NewReader(strings.Join(ranges, "\n")))
+func (f *Fetcher) getOCIRanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.OCI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OCI IP ranges: %w", err)
+	}
+	defer body.Close()
+
+	stats := ParseStatsFrom(ctx)
+	var ranges []*net.IPNet
+	err = decodeJSONArrays(body, map[string]func(*json.Decoder) error{
+		"regions": func(dec *json.Decoder) error {
+			var region struct {
+				Cidrs []struct {
+					Cidr string `json:"cidr"`
+				} `json:"cidrs"`
+			}
+			if err := dec.Decode(&region); err != nil {
+				return err
+			}
+			for _, cidr := range region.Cidrs {
+				ranges = appendIPRange(ranges, cidr.Cidr, stats)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OCI IP ranges JSON: %w", err)
+	}
+	return ranges, nil
 }
 
-func getDORanges() ([]*net.IPNet, error) {
-	resp, err := http.Get(doCIDRURL)
+func (f *Fetcher) getDORanges(ctx context.Context) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, f.Ranges.DigitalOcean)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch DigitalOcean IP ranges: %w", err)
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
-	reader := csv.NewReader(resp.Body)
+	reader := csv.NewReader(body)
 	var ranges []string
 	for {
 		record, err := reader.Read()
@@ -223,25 +421,16 @@ func getDORanges() ([]*net.IPNet, error) {
 		}
 	}
 
-	return parseIPRanges(strings.NewReader(strings.Join(ranges, "\n")))
+	return parseCIDRSlice(ranges, ParseStatsFrom(ctx)), nil
 }
 
-func parseIPRanges(r io.Reader) ([]*net.IPNet, error) {
+func parseIPRanges(r io.Reader, stats *ParseStats) ([]*net.IPNet, error) {
 	var ipNets []*net.IPNet
-	scanner := bufio.NewScanner(r)
+	scanner := NewLineScanner(r)
 	for scanner.Scan() {
-		cidr := strings.TrimSpace(scanner.Text())
-		if cidr == "" {
-			continue
+		if cidr := stripComment(scanner.Text()); cidr != "" {
+			ipNets = appendIPRange(ipNets, cidr, stats)
 		}
-
-		_, ipNet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			// Log the error but continue processing
-			fmt.Printf("Error parsing CIDR %s: %v\n", cidr, err)
-			continue
-		}
-		ipNets = append(ipNets, ipNet)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -250,3 +439,40 @@ func parseIPRanges(r io.Reader) ([]*net.IPNet, error) {
 
 	return ipNets, nil
 }
+
+// parseCIDRSlice parses CIDRs and start-end ranges already split apart,
+// skipping blank and invalid ones.
+func parseCIDRSlice(cidrs []string, stats *ParseStats) []*net.IPNet {
+	var ipNets []*net.IPNet
+	for _, cidr := range cidrs {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			ipNets = appendIPRange(ipNets, cidr, stats)
+		}
+	}
+	return ipNets
+}
+
+// appendIPRange appends the networks of cidr, a CIDR or a start-end range,
+// to ipNets. Invalid entries are counted in stats and skipped.
+func appendIPRange(ipNets []*net.IPNet, cidr string, stats *ParseStats) []*net.IPNet {
+	if strings.Contains(cidr, "-") {
+		networks, err := ParseRange(cidr)
+		if err != nil {
+			stats.Skip("Skipping invalid range", "line", cidr, "error", err)
+			return ipNets
+		}
+		stats.Add()
+		return append(ipNets, networks...)
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		stats.Skip("Skipping invalid CIDR", "line", cidr, "error", err)
+		return ipNets
+	}
+	stats.Add()
+	if ipNet = CanonicalNetwork(ipNet); ipNet != nil {
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets
+}
@@ -0,0 +1,194 @@
+package ip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheHeader starts every file in a Fetcher's CacheDir, followed by the
+// gzipped download. The version changes with the format, and files with any
+// other header are treated as missing.
+var cacheHeader = []byte("ipshield-cache 1\n")
+
+// ErrNotCached is returned by cache only reads of a source that has no copy
+// in the cache directory yet.
+var ErrNotCached = errors.New("not cached")
+
+// DiskWrites holds the downloads of one update until it is known to have
+// parsed, so a bad download never replaces the last good copy on disk. A
+// nil *DiskWrites writes nothing.
+type DiskWrites struct {
+	mu sync.Mutex
+	// files maps each finished temporary file to its place in the cache
+	// directory
+	files map[string]string
+}
+
+// Commit moves the downloads written so far into their cache directory.
+func (w *DiskWrites) Commit() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for temp, path := range w.files {
+		if err := os.Rename(temp, path); err != nil {
+			os.Remove(temp)
+			errs = append(errs, err)
+		}
+	}
+	w.files = nil
+	return errors.Join(errs...)
+}
+
+// Discard drops the downloads written so far, keeping the older copies.
+func (w *DiskWrites) Discard() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for temp := range w.files {
+		os.Remove(temp)
+	}
+	w.files = nil
+}
+
+// tee returns body, copying what is read from it to a temporary file in dir
+// that is added to w once body has been read to the end. An empty dir
+// copies nothing.
+func (w *DiskWrites) tee(dir, source string, body io.ReadCloser) io.ReadCloser {
+	if w == nil || dir == "" {
+		return body
+	}
+	file, err := os.CreateTemp(dir, ".download-*")
+	if err == nil {
+		_, err = file.Write(cacheHeader)
+	}
+	if err != nil {
+		slog.Warn("Not caching download on disk", "url", redactURL(source), "error", err)
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+		return body
+	}
+	return &teeBody{ReadCloser: body, file: file, gz: gzip.NewWriter(file), path: cachePath(dir, source), writes: w}
+}
+
+type teeBody struct {
+	io.ReadCloser
+	file   *os.File
+	gz     *gzip.Writer
+	path   string
+	writes *DiskWrites
+	err    error
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.err == nil {
+		_, b.err = b.gz.Write(p[:n])
+	}
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close copies whatever the parser left unread, e.g. after the end of a
+// JSON document, so the copy on disk is the whole download.
+func (b *teeBody) Close() error {
+	if b.err == nil {
+		_, b.err = io.Copy(b.gz, b.ReadCloser)
+	}
+	if err := b.gz.Close(); b.err == nil {
+		b.err = err
+	}
+	if err := b.file.Close(); b.err == nil {
+		b.err = err
+	}
+
+	if b.err != nil {
+		os.Remove(b.file.Name())
+	} else {
+		b.writes.mu.Lock()
+		if b.writes.files == nil {
+			b.writes.files = make(map[string]string)
+		}
+		b.writes.files[b.file.Name()] = b.path
+		b.writes.mu.Unlock()
+	}
+	return b.ReadCloser.Close()
+}
+
+// cachePath names the copy of source in dir.
+func cachePath(dir, source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(dir, hex.EncodeToString(sum[:]))
+}
+
+// openCached returns the copy of source in dir, decompressed.
+func openCached(dir, source string) (io.ReadCloser, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("%s: %w", redactURL(source), ErrNotCached)
+	}
+	file, err := os.Open(cachePath(dir, source))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", redactURL(source), ErrNotCached)
+	} else if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(cacheHeader))
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.Equal(header, cacheHeader) {
+		file.Close()
+		slog.Warn("Ignoring cached copy in an unknown format", "url", redactURL(source), "path", file.Name())
+		return nil, fmt.Errorf("%s: %w", redactURL(source), ErrNotCached)
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid cached copy of %s: %w", redactURL(source), err)
+	}
+	return &gzipBody{Reader: gz, body: file}, nil
+}
+
+type diskWritesKey struct{}
+
+// WithDiskWrites returns a context whose remote downloads are copied into
+// writes.
+func WithDiskWrites(ctx context.Context, writes *DiskWrites) context.Context {
+	return context.WithValue(ctx, diskWritesKey{}, writes)
+}
+
+func diskWritesFrom(ctx context.Context) *DiskWrites {
+	writes, _ := ctx.Value(diskWritesKey{}).(*DiskWrites)
+	return writes
+}
+
+type cacheOnlyKey struct{}
+
+// WithCacheOnly returns a context whose remote downloads are read from the
+// Fetcher's CacheDir instead of the network, failing with ErrNotCached for
+// sources that were never downloaded.
+func WithCacheOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheOnlyKey{}, true)
+}
+
+func cacheOnly(ctx context.Context) bool {
+	return ctx.Value(cacheOnlyKey{}) != nil
+}
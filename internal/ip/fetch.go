@@ -0,0 +1,314 @@
+package ip
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDownloadTimeout caps a whole download, including reading the body,
+// so a hung upstream can't stall the update loop.
+const DefaultDownloadTimeout = 2 * time.Minute
+
+// ErrNotModified is returned by Fetch when the source answered 304, callers
+// should keep whatever they loaded last time.
+var ErrNotModified = errors.New("not modified")
+
+// DefaultDataCenterConcurrency is how many data center providers a
+// Fetcher downloads at once unless told otherwise.
+const DefaultDataCenterConcurrency = 4
+
+// Fetcher downloads lists. It holds the client, the range locations and what
+// it remembers between downloads, so Fetchers in one process, say one per
+// test, never share any state.
+type Fetcher struct {
+	// Client makes every remote download, e.g. an httptest.Server's client
+	Client *http.Client
+	// UserAgent and Contact identify ipshield to list maintainers on every
+	// download. Contact, e.g. an email address, is sent as the From header
+	// when set.
+	UserAgent string
+	Contact   string
+	// CacheDir, when set, keeps the last good copy of every remote download
+	// so the lists can be loaded from disk at startup, before the network
+	// is reached, see WithCacheOnly. Local sources are never copied.
+	CacheDir string
+	// Ranges locates the data center and CDN ranges
+	Ranges RangeURLs
+	// DataCenterConcurrency bounds how many providers are downloaded at once
+	DataCenterConcurrency int
+
+	validatorsMu sync.Mutex
+	validators   map[string]validators
+
+	lastRangesMu sync.Mutex
+	lastRanges   map[string][]*net.IPNet
+}
+
+// NewFetcher returns a Fetcher with the default client and locations.
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Client:                NewHTTPClient(DefaultDownloadTimeout, nil),
+		UserAgent:             DefaultUserAgent(),
+		Ranges:                DefaultRangeURLs(),
+		DataCenterConcurrency: DefaultDataCenterConcurrency,
+	}
+}
+
+// DefaultUserAgent names ipshield, its version and where it comes from.
+func DefaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "ipshield/" + version + " (+https://github.com/scmmishra/ipshield)"
+}
+
+// NewHTTPClient returns a client for downloads that go through proxy, or
+// the proxy named by HTTPS_PROXY, HTTP_PROXY and NO_PROXY when proxy is
+// nil. Either way it applies to http and https sources alike.
+func NewHTTPClient(timeout time.Duration, proxy *neturl.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// do sends req with Client, adding UserAgent and Contact unless req sets
+// its own.
+func (f *Fetcher) do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" && f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	if req.Header.Get("From") == "" && f.Contact != "" {
+		req.Header.Set("From", f.Contact)
+	}
+	return f.Client.Do(req)
+}
+
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+func (f *Fetcher) cacheValidators(url string) validators {
+	f.validatorsMu.Lock()
+	defer f.validatorsMu.Unlock()
+	return f.validators[url]
+}
+
+func (f *Fetcher) setCacheValidators(url string, v validators) {
+	f.validatorsMu.Lock()
+	defer f.validatorsMu.Unlock()
+	if f.validators == nil {
+		f.validators = make(map[string]validators)
+	}
+	f.validators[url] = v
+}
+
+// PendingValidators holds the ETag and Last-Modified headers of the
+// downloads of one update until the update is accepted, like DiskWrites. A
+// download rejected after it was read, e.g. for shrinking too much, is then
+// fetched in full next time instead of answered 304.
+type PendingValidators struct {
+	mu      sync.Mutex
+	pending []pendingValidator
+}
+
+type pendingValidator struct {
+	fetcher    *Fetcher
+	url        string
+	validators validators
+}
+
+func (p *PendingValidators) add(f *Fetcher, url string, v validators) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, pendingValidator{f, url, v})
+}
+
+// Commit remembers the validators held so far for the next downloads.
+func (p *PendingValidators) Commit() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, v := range p.pending {
+		v.fetcher.setCacheValidators(v.url, v.validators)
+	}
+	p.pending = nil
+}
+
+// Discard drops the validators held so far, keeping the older ones.
+func (p *PendingValidators) Discard() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = nil
+}
+
+type pendingValidatorsKey struct{}
+
+// WithPendingValidators returns a context whose downloads leave their
+// validators in pending. Without one they're remembered as soon as the
+// download has been read to the end.
+func WithPendingValidators(ctx context.Context, pending *PendingValidators) context.Context {
+	return context.WithValue(ctx, pendingValidatorsKey{}, pending)
+}
+
+func pendingValidatorsFrom(ctx context.Context) *PendingValidators {
+	pending, _ := ctx.Value(pendingValidatorsKey{}).(*PendingValidators)
+	return pending
+}
+
+// Fetch issues a conditional GET for url with Client. The caller
+// must close the response body. Any status outside 2xx, other than 304, is
+// an error. The ETag and Last-Modified headers are only
+// remembered once the body has been read to the end, so a download that
+// fails halfway is fetched in full next time, and with WithPendingValidators
+// only once the caller accepts it.
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPresigned(req.URL, time.Now()); err != nil {
+		return nil, err
+	}
+
+	v := f.cacheValidators(url)
+
+	// Setting this ourselves turns off the transport's transparent
+	// decompression, so gzip bodies are unwrapped below instead
+	req.Header.Set("Accept-Encoding", "gzip")
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+
+	// An error page must never be parsed as a list
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		slog.Warn("Download answered with an error status", "url", redactURL(url), "status", resp.StatusCode)
+		return nil, fmt.Errorf("%s answered %s", redactURL(url), resp.Status)
+	}
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("invalid gzip response from %s: %w", redactURL(url), err)
+		}
+		resp.Body = &gzipBody{Reader: gz, body: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+
+	resp.Body = &validatingBody{
+		ReadCloser: resp.Body,
+		fetcher:    f,
+		pending:    pendingValidatorsFrom(ctx),
+		url:        url,
+		validators: validators{
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+		},
+	}
+	return resp, nil
+}
+
+// Open returns the contents of source, which may be an http(s) URL, a
+// file:// URL or a plain filesystem path. Remote sources go through Fetch
+// and so can return ErrNotModified. Object stores are read through
+// presigned https URLs. Remote sources are copied to CacheDir, or read from
+// it instead, see WithDiskWrites and WithCacheOnly.
+func (f *Fetcher) Open(ctx context.Context, source string) (io.ReadCloser, error) {
+	if strings.HasPrefix(source, "s3://") {
+		return nil, fmt.Errorf("%s: s3:// URLs aren't supported, use a presigned https URL", source)
+	}
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if cacheOnly(ctx) {
+			return openCached(f.CacheDir, source)
+		}
+		resp, err := f.Fetch(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return diskWritesFrom(ctx).tee(f.CacheDir, source, resp.Body), nil
+	}
+
+	if strings.HasPrefix(source, "file://") {
+		u, err := neturl.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		source = u.Path
+	}
+	return os.Open(source)
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+type validatingBody struct {
+	io.ReadCloser
+	fetcher    *Fetcher
+	pending    *PendingValidators
+	url        string
+	validators validators
+	read       bool
+}
+
+func (b *validatingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && !b.read {
+		b.read = true
+		if b.pending != nil {
+			b.pending.add(b.fetcher, b.url, b.validators)
+		} else {
+			b.fetcher.setCacheValidators(b.url, b.validators)
+		}
+	}
+	return n, err
+}
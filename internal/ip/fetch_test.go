@@ -0,0 +1,95 @@
+package ip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newListServer serves fixed lists with an ETag, answering 304 to requests
+// that already have it, and records the User-Agent of each request.
+func newListServer(t *testing.T, lists map[string]string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		body, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &agents
+}
+
+func TestFetcherSources(t *testing.T) {
+	t.Parallel()
+	server, agents := newListServer(t, map[string]string{
+		"/firehol.netset": "# comment\n192.0.2.0/24\n198.51.100.7\n2001:db8::/32\n",
+		"/tor.txt":        "203.0.113.1\n2001:db8::1\nbogus\n",
+		"/cf-v4":          "198.51.100.0/24\n",
+		"/cf-v6":          "2001:db8:cf::/48\n",
+	})
+	f := &Fetcher{Client: server.Client(), UserAgent: "ipshield-test"}
+	f.Ranges.CloudflareIPv4 = server.URL + "/cf-v4"
+	f.Ranges.CloudflareIPv6 = server.URL + "/cf-v6"
+
+	tests := []struct {
+		source   Source
+		ips      int
+		networks int
+	}{
+		{NewNetsetSource(f, "firehol", "FLAGGED", server.URL+"/firehol.netset"), 0, 3},
+		{NewIPListSource(f, "tor", "TOR_EXIT", server.URL+"/tor.txt"), 2, 0},
+		{NewCDNSource(f, "CDN"), 0, 2},
+	}
+	for _, tt := range tests {
+		ips, networks, err := tt.source.Fetch(context.Background())
+		if err != nil || len(ips) != tt.ips || len(networks) != tt.networks {
+			t.Errorf("%s: got %d IPs and %d networks, %v, want %d and %d", tt.source.Name(), len(ips), len(networks), err, tt.ips, tt.networks)
+		}
+	}
+	for _, agent := range *agents {
+		if agent != "ipshield-test" {
+			t.Errorf("request sent with User-Agent %q, want ipshield-test", agent)
+		}
+	}
+
+	// Unchanged lists aren't downloaded again, ranges are carried over
+	if _, _, err := tests[0].source.Fetch(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Errorf("second firehol fetch = %v, want ErrNotModified", err)
+	}
+	if _, networks, err := tests[2].source.Fetch(context.Background()); err != nil || len(networks) != 2 {
+		t.Errorf("second CDN fetch = %d networks, %v, want the 2 from before", len(networks), err)
+	}
+}
+
+func TestFetchersShareNoState(t *testing.T) {
+	t.Parallel()
+	server, _ := newListServer(t, map[string]string{"/list": "192.0.2.1\n"})
+
+	first := &Fetcher{Client: server.Client()}
+	second := &Fetcher{Client: server.Client()}
+	source := server.URL + "/list"
+
+	if _, err := first.GetFireholNetworks(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.GetFireholNetworks(context.Background(), source); !errors.Is(err, ErrNotModified) {
+		t.Errorf("first fetcher again = %v, want ErrNotModified", err)
+	}
+	networks, err := second.GetFireholNetworks(context.Background(), source)
+	if err != nil || len(networks) != 1 {
+		t.Errorf("second fetcher = %d networks, %v, want the whole list", len(networks), err)
+	}
+}
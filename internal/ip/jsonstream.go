@@ -0,0 +1,78 @@
+package ip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// decodeJSONArrays reads the JSON object in r key by key. The elements of
+// each array named in arrays are handed to its function one at a time, to
+// decode with the given decoder, and every other value is skipped, so large
+// range files are never held in memory whole.
+func decodeJSONArrays(r io.Reader, arrays map[string]func(*json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+
+		element, ok := arrays[key]
+		if !ok {
+			if err := skipJSONValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		for dec.More() {
+			if err := element(dec); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
+
+// skipJSONValue reads past the next value, however deeply nested, without
+// keeping it.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package ip
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// MaxLineLength bounds a single line of a downloaded list.
+const MaxLineLength = 64 * 1024
+
+// NewLineScanner returns a scanner over the lines of r, like
+// bufio.NewScanner, except that lines longer than MaxLineLength are logged
+// and skipped rather than failing the whole scan with bufio.ErrTooLong.
+func NewLineScanner(r io.Reader) *bufio.Scanner {
+	skipping := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MaxLineLength)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= MaxLineLength {
+			slog.Warn("Skipping over-long line", "prefix", string(data[:min(len(data), 64)]))
+			skipping = true
+			return len(data), nil, nil
+		}
+		return advance, token, err
+	})
+	return scanner
+}
+
+// stripComment returns line without a trailing # comment and surrounding
+// whitespace, so "1.2.3.0/24  # spam" reads as "1.2.3.0/24". Whole line
+// comments come back empty.
+func stripComment(line string) string {
+	line, _, _ = strings.Cut(line, "#")
+	return strings.TrimSpace(line)
+}
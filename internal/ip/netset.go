@@ -0,0 +1,61 @@
+package ip
+
+import (
+	"io"
+	"net"
+	"strings"
+)
+
+// ParseNetset reads a list of CIDRs, start-end ranges and bare IPs, one per
+// line, skipping blanks and # comments, including ones after an entry. Bare
+// IPs become single address networks. Entries and malformed lines are
+// counted into stats.
+func ParseNetset(r io.Reader, stats *ParseStats) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	scanner := NewLineScanner(r)
+	for scanner.Scan() {
+		line := stripComment(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.Contains(line, "-") {
+			ranges, err := ParseRange(line)
+			if err != nil {
+				stats.Skip("Skipping invalid range", "line", line, "error", err)
+				continue
+			}
+			stats.Add()
+			networks = append(networks, ranges...)
+			continue
+		}
+
+		if !strings.Contains(line, "/") {
+			addr := net.ParseIP(line)
+			if addr == nil {
+				stats.Skip("Skipping invalid IP", "line", line)
+				continue
+			}
+			stats.Add()
+			networks = append(networks, HostNetwork(addr))
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(line)
+		if err != nil {
+			stats.Skip("Skipping invalid CIDR", "line", line, "error", err)
+			continue
+		}
+		stats.Add()
+		if ipNet = CanonicalNetwork(ipNet); ipNet != nil {
+			networks = append(networks, ipNet)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,45 @@
+package ip
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Lists staged in S3 or another object store are read through presigned
+// https URLs, which need no SDK or credentials here. Their query string
+// carries the signature, so it is kept out of logs and errors.
+
+// redactURL drops the query string of rawURL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	u.RawQuery = "REDACTED"
+	return u.String()
+}
+
+// checkPresigned fails once a SigV4 presigned URL has expired, which the
+// store would otherwise only report as a bare 403. Other URLs always pass.
+func checkPresigned(u *url.URL, now time.Time) error {
+	query := u.Query()
+	signed, expires := query.Get("X-Amz-Date"), query.Get("X-Amz-Expires")
+	if signed == "" || expires == "" {
+		return nil
+	}
+
+	at, err := time.Parse("20060102T150405Z", signed)
+	if err != nil {
+		return nil
+	}
+	seconds, err := strconv.Atoi(expires)
+	if err != nil {
+		return nil
+	}
+	if deadline := at.Add(time.Duration(seconds) * time.Second); now.After(deadline) {
+		return fmt.Errorf("presigned URL %s expired at %s, sign a new one", redactURL(u.String()), deadline.Format(time.RFC3339))
+	}
+	return nil
+}
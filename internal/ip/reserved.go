@@ -0,0 +1,51 @@
+package ip
+
+import "net"
+
+// reservedNetworks holds the IANA special-purpose ranges that aren't
+// routable on the public internet, other than the private ones net.IP's
+// IsPrivate already covers.
+//
+// https://www.iana.org/assignments/iana-ipv4-special-registry
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var reservedNetworks = mustParseNetworks(
+	"0.0.0.0/8",       // "this network"
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.88.99.0/24",  // deprecated 6to4 relay anycast
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, including broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"64:ff9b:1::/48",  // local-use IPv4/IPv6 translation
+	"100::/64",        // discard-only
+	"2001:db8::/32",   // documentation
+	"3fff::/20",       // documentation
+	"fe80::/10",       // link-local
+	"ff00::/8",        // multicast
+)
+
+// IsReserved reports whether ip is in any other special-purpose range that
+// isn't globally routable, such as loopback, link-local, documentation or
+// multicast addresses.
+func IsReserved(ip net.IP) bool {
+	return reservedNetworks.Contains(ip)
+}
+
+func mustParseNetworks(cidrs ...string) *PrefixTrie {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = CanonicalNetwork(network)
+	}
+	return NewPrefixTrie(networks)
+}
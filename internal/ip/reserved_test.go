@@ -0,0 +1,41 @@
+package ip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsReserved(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"0.0.0.0", true},
+		{"127.0.0.1", true},
+		{"127.255.255.255", true},
+		{"169.254.1.1", true},
+		{"100.64.0.1", true},
+		{"192.0.2.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"febf:ffff::1", true},
+		{"ff02::1", true},
+		{"2001:db8::1", true},
+		{"::ffff:127.0.0.1", true},
+		// Private ranges are left to net.IP.IsPrivate
+		{"10.0.0.1", false},
+		{"fd00::1", false},
+		{"8.8.8.8", false},
+		{"100.128.0.1", false},
+		{"fec0::1", false},
+		{"2606:4700::1", false},
+	}
+	for _, tt := range tests {
+		if got := IsReserved(net.ParseIP(tt.addr)); got != tt.want {
+			t.Errorf("IsReserved(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ip
+
+import (
+	"net"
+	"sort"
+)
+
+// IPSet holds exact addresses keyed by their 16-byte form, so the 4-byte and
+// 16-byte representations of an IPv4 address are the same member. Unlike
+// Canonical this always uses 16 bytes, giving every key the same length.
+type IPSet map[string]struct{}
+
+func (s IPSet) Add(ip net.IP) {
+	if key := ip.To16(); key != nil {
+		s[string(key)] = struct{}{}
+	}
+}
+
+func (s IPSet) Contains(ip net.IP) bool {
+	_, ok := s[string(ip.To16())]
+	return ok
+}
+
+// Networks returns every member as a single address network.
+func (s IPSet) Networks() []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(s))
+	for key := range s {
+		networks = append(networks, HostNetwork(net.IP(key)))
+	}
+	return networks
+}
+
+// SortedIPs holds the members of an IPSet in address order, so the ones
+// inside a network are found without walking the whole set. Like
+// PrefixTrie it keeps IPv4 and IPv6 apart.
+type SortedIPs struct {
+	v4 []string
+	v6 []string
+}
+
+func NewSortedIPs(s IPSet) SortedIPs {
+	var sorted SortedIPs
+	for key := range s {
+		if v4 := net.IP(key).To4(); v4 != nil {
+			sorted.v4 = append(sorted.v4, string(v4))
+		} else {
+			sorted.v6 = append(sorted.v6, key)
+		}
+	}
+	sort.Strings(sorted.v4)
+	sort.Strings(sorted.v6)
+	return sorted
+}
+
+func (s SortedIPs) Len() int {
+	return len(s.v4) + len(s.v6)
+}
+
+// Overlapping returns the members inside network in address order,
+// stopping after limit.
+func (s SortedIPs) Overlapping(network *net.IPNet, limit int) []net.IP {
+	network = CanonicalNetwork(network)
+	if network == nil {
+		return nil
+	}
+
+	keys := s.v4
+	if len(network.IP) == net.IPv6len {
+		keys = s.v6
+	}
+	first, last := network.IP, make(net.IP, len(network.IP))
+	for i := range last {
+		last[i] = first[i] | ^network.Mask[i]
+	}
+
+	var ips []net.IP
+	for i := sort.SearchStrings(keys, string(first)); i < len(keys) && len(ips) < limit && keys[i] <= string(last); i++ {
+		ips = append(ips, net.IP(keys[i]))
+	}
+	return ips
+}
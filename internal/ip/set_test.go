@@ -0,0 +1,67 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestSortedIPsOverlapping(t *testing.T) {
+	set := make(IPSet)
+	for _, addr := range []string{"192.0.2.9", "192.0.2.1", "192.0.3.0", "192.0.1.255", "2001:db8::1", "2001:db8::ffff", "2001:db9::"} {
+		set.Add(net.ParseIP(addr))
+	}
+	sorted := NewSortedIPs(set)
+	if sorted.Len() != len(set) {
+		t.Fatalf("Len() = %d, want %d", sorted.Len(), len(set))
+	}
+
+	tests := []struct {
+		network string
+		limit   int
+		want    []string
+	}{
+		{"192.0.2.0/24", 10, []string{"192.0.2.1", "192.0.2.9"}},
+		{"192.0.2.0/24", 1, []string{"192.0.2.1"}},
+		{"192.0.0.0/16", 10, []string{"192.0.1.255", "192.0.2.1", "192.0.2.9", "192.0.3.0"}},
+		{"192.0.2.9/32", 10, []string{"192.0.2.9"}},
+		{"::ffff:192.0.2.0/120", 10, []string{"192.0.2.1", "192.0.2.9"}},
+		{"2001:db8::/32", 10, []string{"2001:db8::1", "2001:db8::ffff"}},
+		// IPv4 members aren't inside IPv6 networks, mapped or not
+		{"::/0", 10, []string{"2001:db8::1", "2001:db8::ffff", "2001:db9::"}},
+		{"0.0.0.0/0", 0, nil},
+		{"198.51.100.0/24", 10, nil},
+	}
+	for _, tt := range tests {
+		_, network, err := net.ParseCIDR(tt.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, addr := range sorted.Overlapping(network, tt.limit) {
+			got = append(got, addr.String())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Overlapping(%s, %d) = %v, want %v", tt.network, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkIPSetContains(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			set := make(IPSet, n)
+			for _, addr := range syntheticAddrs(n, false) {
+				set.Add(addr)
+			}
+			// Half members, half not
+			addrs := append(syntheticAddrs(n, false)[:512], syntheticAddrs(512, false)...)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				set.Contains(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
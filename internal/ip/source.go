@@ -0,0 +1,175 @@
+package ip
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Source is a list of addresses and networks to match lookups against.
+// Adding a list means adding a Source, the updater takes care of
+// scheduling, size checks and swapping it in. The constructors below
+// download through the Fetcher they are given.
+type Source interface {
+	// Name identifies the source in logs, metrics and answers, e.g.
+	// "firehol"
+	Name() string
+	// Category is reported for addresses the source lists, e.g. "FLAGGED"
+	Category() string
+	// Fetch downloads and parses the list. Like Open it returns
+	// ErrNotModified when nothing changed since the last fetch. Sources
+	// made of several downloads may return what they got along with an
+	// error when only some of them failed.
+	Fetch(ctx context.Context) ([]net.IP, []*net.IPNet, error)
+}
+
+// source implements Source around a fetch function.
+type source struct {
+	name     string
+	category string
+	fetch    func(context.Context) ([]net.IP, []*net.IPNet, error)
+}
+
+func (s *source) Name() string     { return s.name }
+func (s *source) Category() string { return s.category }
+
+func (s *source) Fetch(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+	return s.fetch(ctx)
+}
+
+// NewNetsetSource reads a netset of CIDRs, ranges and IPs from url, see
+// ParseNetset.
+func NewNetsetSource(f *Fetcher, name, category, url string) Source {
+	return &source{name, category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		networks, err := f.GetFireholNetworks(ctx, url)
+		return nil, networks, err
+	}}
+}
+
+// NewIPListSource reads a plain list of IPs from url, one per line.
+func NewIPListSource(f *Fetcher, name, category, url string) Source {
+	return &source{name, category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		body, err := f.Open(ctx, url)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer body.Close()
+
+		ips, err := parseIPList(body, name, 0, ParseStatsFrom(ctx))
+		return ips, nil, err
+	}}
+}
+
+// NewIpsumSource reads the IPsum list from url, keeping the IPs listed by
+// at least minScore of the blocklists it aggregates.
+func NewIpsumSource(f *Fetcher, category, url string, minScore int) Source {
+	return &source{"ipsum", category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		body, err := f.Open(ctx, url)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer body.Close()
+
+		ips, err := parseIPList(body, "ipsum", minScore, ParseStatsFrom(ctx))
+		return ips, nil, err
+	}}
+}
+
+// NewSpamhausSource reads the DROP style lists at urls, skipping empty
+// ones, see GetSpamhausDropRanges.
+func NewSpamhausSource(f *Fetcher, category string, urls ...string) Source {
+	var sources []string
+	for _, url := range urls {
+		if url != "" {
+			sources = append(sources, url)
+		}
+	}
+	return &source{"drop", category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		networks, err := f.GetSpamhausDropRanges(ctx, sources...)
+		return nil, networks, err
+	}}
+}
+
+// NewDataCenterSource reads the data center ranges, see
+// GetDataCenterIPRanges. Providers that failed leave the others' ranges.
+func NewDataCenterSource(f *Fetcher, category string) Source {
+	return &source{"datacenter", category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		networks, err := f.GetDataCenterIPRanges(ctx)
+		return nil, networks, err
+	}}
+}
+
+// NewCDNSource reads the CDN ranges, see GetCDNIPRanges.
+func NewCDNSource(f *Fetcher, category string) Source {
+	return &source{"cdn", category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		networks, err := f.GetCDNIPRanges(ctx)
+		return nil, networks, err
+	}}
+}
+
+// NewAbuseIPDBSource queries the AbuseIPDB blacklist, see
+// GetAbuseIPDBBlacklist.
+func NewAbuseIPDBSource(f *Fetcher, category, endpoint, apiKey string, minConfidence int) Source {
+	return &source{"abuseipdb", category, func(ctx context.Context) ([]net.IP, []*net.IPNet, error) {
+		set, err := f.GetAbuseIPDBBlacklist(ctx, endpoint, apiKey, minConfidence)
+		if err != nil {
+			return nil, nil, err
+		}
+		ips := make([]net.IP, 0, len(set))
+		for key := range set {
+			ips = append(ips, net.IP(key))
+		}
+		return ips, nil, nil
+	}}
+}
+
+// parseIPList reads one IP per line, skipping blanks and # comments. With a
+// minScore above 0 the second column, the IPsum count of blocklists listing
+// the IP, must reach it. Lines without one count as a single list.
+func parseIPList(r io.Reader, name string, minScore int, stats *ParseStats) ([]net.IP, error) {
+	var ips []net.IP
+	belowScore := 0
+
+	scanner := NewLineScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			stats.Skip("Skipping invalid IP", "source", name, "line", fields[0])
+			continue
+		}
+
+		if minScore > 0 {
+			score := 1
+			if len(fields) > 1 {
+				var err error
+				if score, err = strconv.Atoi(fields[1]); err != nil {
+					stats.Skip("Skipping invalid score", "source", name, "line", scanner.Text())
+					continue
+				}
+			}
+			if score < minScore {
+				stats.Add()
+				belowScore++
+				continue
+			}
+		}
+		stats.Add()
+		ips = append(ips, ip)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if belowScore > 0 {
+		slog.Info("Dropped IPs below the minimum score", "source", name, "below_min_score", belowScore)
+	}
+	return ips, nil
+}
@@ -0,0 +1,63 @@
+package ip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// GetSpamhausDropRanges downloads the Spamhaus DROP and EDROP style lists at
+// urls and merges them. Each line is a CIDR, optionally followed by
+// "; SBL id", with ; starting comments.
+func (f *Fetcher) GetSpamhausDropRanges(ctx context.Context, urls ...string) ([]*net.IPNet, error) {
+	var allRanges []*net.IPNet
+	for _, url := range urls {
+		ranges, err := f.withLastRanges(ctx, url, func(ctx context.Context) ([]*net.IPNet, error) {
+			return f.getSpamhausRanges(ctx, url)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Spamhaus: %w", err)
+		}
+		allRanges = append(allRanges, ranges...)
+	}
+
+	return CoalesceNetworks(allRanges), nil
+}
+
+func (f *Fetcher) getSpamhausRanges(ctx context.Context, url string) ([]*net.IPNet, error) {
+	body, err := f.Open(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", redactURL(url), err)
+	}
+	defer body.Close()
+
+	return parseSpamhausDrop(body, ParseStatsFrom(ctx))
+}
+
+func parseSpamhausDrop(r io.Reader, stats *ParseStats) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	scanner := NewLineScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ";")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(line)
+		if err != nil {
+			stats.Skip("Skipping invalid CIDR", "source", "drop", "line", line, "error", err)
+			continue
+		}
+		stats.Add()
+		networks = append(networks, CanonicalNetwork(ipNet))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
@@ -0,0 +1,52 @@
+package ip
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// ParseStats counts the entries one update parsed and the malformed lines
+// it skipped, so a feed going bad shows up as a number instead of a flood
+// of log lines. Providers parsed concurrently share one, and a nil
+// *ParseStats counts nothing.
+type ParseStats struct {
+	parsed  atomic.Int64
+	skipped atomic.Int64
+}
+
+// Add counts a parsed entry.
+func (s *ParseStats) Add() {
+	if s != nil {
+		s.parsed.Add(1)
+	}
+}
+
+// Skip counts a malformed line, logged at debug level only.
+func (s *ParseStats) Skip(msg string, args ...any) {
+	slog.Debug(msg, args...)
+	if s != nil {
+		s.skipped.Add(1)
+	}
+}
+
+// Counts returns the entries parsed and lines skipped so far.
+func (s *ParseStats) Counts() (parsed, skipped int64) {
+	if s == nil {
+		return 0, 0
+	}
+	return s.parsed.Load(), s.skipped.Load()
+}
+
+type parseStatsKey struct{}
+
+// WithParseStats returns a context whose downloads count into stats.
+func WithParseStats(ctx context.Context, stats *ParseStats) context.Context {
+	return context.WithValue(ctx, parseStatsKey{}, stats)
+}
+
+// ParseStatsFrom returns the stats set with WithParseStats, or nil.
+func ParseStatsFrom(ctx context.Context) *ParseStats {
+	stats, _ := ctx.Value(parseStatsKey{}).(*ParseStats)
+	return stats
+}
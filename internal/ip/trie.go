@@ -0,0 +1,174 @@
+package ip
+
+import "net"
+
+// PrefixTrie is a binary trie over address bits used to test membership in a
+// set of CIDRs in O(address bits) rather than O(number of networks).
+// IPv4 and IPv6 prefixes live in separate roots.
+type PrefixTrie struct {
+	v4   *trieNode
+	v6   *trieNode
+	size int
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	network  *net.IPNet
+}
+
+func NewPrefixTrie(networks []*net.IPNet) *PrefixTrie {
+	t := &PrefixTrie{v4: &trieNode{}, v6: &trieNode{}}
+	for _, network := range networks {
+		t.Insert(network)
+	}
+	return t
+}
+
+func (t *PrefixTrie) Insert(network *net.IPNet) {
+	network = CanonicalNetwork(network)
+	if network == nil {
+		return
+	}
+
+	ones, bits := network.Mask.Size()
+	var node *trieNode
+	var addr net.IP
+	switch bits {
+	case 8 * net.IPv4len:
+		node, addr = t.v4, network.IP.To4()
+	case 8 * net.IPv6len:
+		node, addr = t.v6, network.IP.To16()
+	}
+	if node == nil || addr == nil {
+		return
+	}
+
+	for i := 0; i < ones; i++ {
+		// A shorter prefix already covers this one
+		if node.network != nil {
+			return
+		}
+
+		bit := addr[i/8] >> (7 - uint(i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+
+	if node.network == nil {
+		t.size++
+	}
+	t.size -= countNetworks(node.children[0]) + countNetworks(node.children[1])
+	node.network = network
+	node.children = [2]*trieNode{}
+}
+
+func countNetworks(node *trieNode) int {
+	if node == nil {
+		return 0
+	}
+	if node.network != nil {
+		return 1
+	}
+	return countNetworks(node.children[0]) + countNetworks(node.children[1])
+}
+
+// Lookup returns the network containing ip, if any.
+func (t *PrefixTrie) Lookup(ip net.IP) (*net.IPNet, bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	node, addr := t.v4, ip.To4()
+	if addr == nil {
+		node, addr = t.v6, ip.To16()
+	}
+	if addr == nil {
+		return nil, false
+	}
+
+	for i := 0; node != nil; i++ {
+		if node.network != nil {
+			return node.network, true
+		}
+		if i == 8*len(addr) {
+			break
+		}
+		node = node.children[addr[i/8]>>(7-uint(i%8))&1]
+	}
+	return nil, false
+}
+
+func (t *PrefixTrie) Contains(ip net.IP) bool {
+	_, ok := t.Lookup(ip)
+	return ok
+}
+
+// Len reports the number of distinct prefixes held, ignoring any that were
+// already covered by a shorter one.
+func (t *PrefixTrie) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+// Networks returns every prefix held, IPv4 first.
+func (t *PrefixTrie) Networks() []*net.IPNet {
+	if t == nil {
+		return nil
+	}
+	networks := make([]*net.IPNet, 0, t.size)
+	networks = appendNetworks(networks, t.v4)
+	return appendNetworks(networks, t.v6)
+}
+
+func appendNetworks(networks []*net.IPNet, node *trieNode) []*net.IPNet {
+	if node == nil {
+		return networks
+	}
+	if node.network != nil {
+		return append(networks, node.network)
+	}
+	networks = appendNetworks(networks, node.children[0])
+	return appendNetworks(networks, node.children[1])
+}
+
+// Overlapping returns the prefixes that overlap network, either containing
+// it or lying inside it, stopping after limit.
+func (t *PrefixTrie) Overlapping(network *net.IPNet, limit int) []*net.IPNet {
+	network = CanonicalNetwork(network)
+	if t == nil || network == nil {
+		return nil
+	}
+
+	node, addr := t.v4, network.IP.To4()
+	if len(network.IP) == net.IPv6len {
+		node, addr = t.v6, network.IP
+	}
+
+	ones, _ := network.Mask.Size()
+	for i := 0; node != nil && i < ones; i++ {
+		if node.network != nil {
+			return []*net.IPNet{node.network}
+		}
+		node = node.children[addr[i/8]>>(7-uint(i%8))&1]
+	}
+
+	var networks []*net.IPNet
+	var walk func(node *trieNode)
+	walk = func(node *trieNode) {
+		if node == nil || len(networks) >= limit {
+			return
+		}
+		if node.network != nil {
+			networks = append(networks, node.network)
+			return
+		}
+		walk(node.children[0])
+		walk(node.children[1])
+	}
+	walk(node)
+	return networks
+}
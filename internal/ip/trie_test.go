@@ -0,0 +1,172 @@
+package ip
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"testing"
+)
+
+// syntheticNetworks returns n random networks of one family, seeded so every
+// run benchmarks the same lists. IPv4 prefixes are /16 to /32, IPv6 ones
+// /32 to /128, roughly the spread of the real lists.
+func syntheticNetworks(n int, v6 bool) []*net.IPNet {
+	r := rand.New(rand.NewSource(int64(n)))
+	size, minOnes := net.IPv4len, 16
+	if v6 {
+		size, minOnes = net.IPv6len, 32
+	}
+
+	networks := make([]*net.IPNet, n)
+	for i := range networks {
+		addr := make(net.IP, size)
+		r.Read(addr)
+		mask := net.CIDRMask(minOnes+r.Intn(8*size-minOnes+1), 8*size)
+		networks[i] = &net.IPNet{IP: addr.Mask(mask), Mask: mask}
+	}
+	return networks
+}
+
+// syntheticAddrs returns n random addresses of one family.
+func syntheticAddrs(n int, v6 bool) []net.IP {
+	r := rand.New(rand.NewSource(int64(n) + 1))
+	size := net.IPv4len
+	if v6 {
+		size = net.IPv6len
+	}
+
+	addrs := make([]net.IP, n)
+	for i := range addrs {
+		addrs[i] = make(net.IP, size)
+		r.Read(addrs[i])
+	}
+	return addrs
+}
+
+var benchmarkSizes = []int{1_000, 10_000, 100_000}
+
+func BenchmarkLinearScan(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			networks := syntheticNetworks(n, false)
+			addrs := syntheticAddrs(1024, false)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				addr := addrs[i%len(addrs)]
+				for _, network := range networks {
+					if network.Contains(addr) {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPrefixTrieContains(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			trie := NewPrefixTrie(syntheticNetworks(n, false))
+			addrs := syntheticAddrs(1024, false)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				trie.Contains(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
+
+func BenchmarkPrefixTrieLookup(b *testing.B) {
+	for _, family := range []struct {
+		name string
+		v6   bool
+	}{{"v4", false}, {"v6", true}} {
+		for _, n := range benchmarkSizes {
+			b.Run(fmt.Sprintf("%s/%d", family.name, n), func(b *testing.B) {
+				trie := NewPrefixTrie(syntheticNetworks(n, family.v6))
+				addrs := syntheticAddrs(1024, family.v6)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					trie.Lookup(addrs[i%len(addrs)])
+				}
+			})
+		}
+	}
+}
+
+func TestPrefixTrieIPv6(t *testing.T) {
+	var networks []*net.IPNet
+	for _, s := range []string{
+		"2600:1f14:8000::/36",
+		"2600:1f14::/32", // covers the /36
+		"2a05:d018::/33",
+		"2a05:d018:8000::/33",
+		"2001:db8::1/128",
+		"::/96", // must not catch IPv4 queries
+		"10.0.0.0/8",
+	} {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+	trie := NewPrefixTrie(networks)
+
+	if got := networkStrings(trie.Networks()); !slices.Equal(got, []string{
+		"10.0.0.0/8", "::/96", "2001:db8::1/128", "2600:1f14::/32", "2a05:d018::/33", "2a05:d018:8000::/33",
+	}) {
+		t.Errorf("Networks() = %v", got)
+	}
+	if trie.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", trie.Len())
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"2600:1f14:8fff::1", "2600:1f14::/32"},
+		{"2600:1f15::", ""},
+		{"2a05:d018:7fff:ffff:ffff:ffff:ffff:ffff", "2a05:d018::/33"},
+		{"2a05:d018:8000::", "2a05:d018:8000::/33"},
+		{"2a05:d019::", ""},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::2", ""},
+		{"::1.2.3.4", "::/96"},
+		// Full 128 bits: differs from the /128 only in the last bit
+		{"2001:db8::", ""},
+		{"1.2.3.4", ""},
+		{"::ffff:10.1.2.3", "10.0.0.0/8"},
+	}
+	for _, tt := range tests {
+		network, ok := trie.Lookup(net.ParseIP(tt.addr))
+		got := ""
+		if ok {
+			got = network.String()
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	overlapping := []struct {
+		network string
+		want    []string
+	}{
+		{"2600:1f14:1234::/48", []string{"2600:1f14::/32"}},
+		{"2a05:d018::/32", []string{"2a05:d018::/33", "2a05:d018:8000::/33"}},
+		{"2001:db8::/32", []string{"2001:db8::1/128"}},
+		{"2001:db9::/32", []string{}},
+	}
+	for _, tt := range overlapping {
+		_, network, _ := net.ParseCIDR(tt.network)
+		if got := networkStrings(trie.Overlapping(network, 10)); !slices.Equal(got, tt.want) {
+			t.Errorf("Overlapping(%s) = %v, want %v", tt.network, got, tt.want)
+		}
+	}
+}
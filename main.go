@@ -1,361 +1,769 @@
 package main
 
 import (
-	"bufio"
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/oschwald/maxminddb-golang"
 	"github.com/scmmishra/ipshield/internal/ip"
 )
 
 const (
-	fireHolURL        = "https://iplists.firehol.org/files/firehol_level1.netset"
-	torExitNodeURL    = "https://check.torproject.org/torbulkexitlist"
-	ipsumURL          = "https://raw.githubusercontent.com/stamparm/ipsum/master/ipsum.txt"
-	greensnowURL      = "https://blocklist.greensnow.co/greensnow.txt"
-	updateInterval    = 6 * time.Hour
-	initialRetryDelay = 5 * time.Second
-	maxRetryDelay     = 5 * time.Minute
-	cacheTTL          = 3600 // 1 hour in seconds
+	categoryFlagged    = "FLAGGED"
+	categoryDataCenter = "DATACENTER"
+	categoryTorExit    = "TOR_EXIT"
+	categoryCDN        = "CDN"
+	categoryProxy      = "PROXY"
+	categorySafe       = "SAFE"
+	categoryPrivate    = "PRIVATE"
+	categoryReserved   = "RESERVED"
 )
 
-var (
-	blockedNetworks    []*net.IPNet
-	dataCenterNetworks []*net.IPNet
-	torExitNodes       []net.IP
-	ipsumIPs           []net.IP
-	greensnowIPs       []net.IP
-	networksMutex      sync.RWMutex
-)
+// returnCodes maps categories to the 127.0.0.x address answered for A
+// queries, following the usual DNSBL convention.
+var returnCodes = map[string]net.IP{
+	categoryFlagged:    net.IPv4(127, 0, 0, 2),
+	categoryDataCenter: net.IPv4(127, 0, 0, 3),
+	categoryTorExit:    net.IPv4(127, 0, 0, 4),
+	categoryCDN:        net.IPv4(127, 0, 0, 5),
+	categoryProxy:      net.IPv4(127, 0, 0, 6),
+}
 
-func main() {
-	if err := downloadAndParseFireholList(); err != nil {
-		log.Printf("Failed to download and parse Firehol list: %v", err)
-		log.Println("Starting with an empty list. Will retry in the background.")
+// returnCodesFor lists the A record addresses for categories. Categories in
+// blocked answer FLAGGED's code instead of their own, for clients that only
+// tell listed from unlisted, and each code is answered once.
+func returnCodesFor(categories, blocked []string) []net.IP {
+	var codes []net.IP
+	for _, category := range categories {
+		if slices.Contains(blocked, category) {
+			category = categoryFlagged
+		}
+		code, ok := returnCodes[category]
+		if !ok || slices.ContainsFunc(codes, code.Equal) {
+			continue
+		}
+		codes = append(codes, code)
 	}
+	return codes
+}
 
-	if err := downloadAndParseTorExitNodes(); err != nil {
-		log.Printf("Failed to download and parse Tor exit node list: %v", err)
-		log.Println("Starting with an empty Tor exit node list. Will retry in the background.")
+func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:], os.Stdout))
+		case "dump":
+			os.Exit(runDump(os.Args[2:], os.Stdout))
+		}
 	}
 
-	if err := downloadAndParseIpsumList(); err != nil {
-		log.Printf("Failed to download and parse IPsum list: %v", err)
-		log.Println("Starting with an empty IPsum list. Will retry in the background.")
+	var validate bool
+	cfg, args, err := loadConfig(os.Args[1:], func(fs *flag.FlagSet) {
+		fs.BoolVar(&validate, "validate", false, "download and parse every source once, print the result and exit, non-zero if any failed")
+	})
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
 	}
-
-	if err := downloadAndParseGreensnowList(); err != nil {
-		log.Printf("Failed to download and parse Greensnow list: %v", err)
-		log.Println("Starting with an empty Greensnow list. Will retry in the background.")
+	if len(args) > 0 {
+		slog.Error("Unexpected arguments, expected a subcommand of check or dump", "args", args)
+		os.Exit(1)
 	}
-
-	// Download data center IP ranges
-	dataCenterRanges, err := ip.GetDataCenterIPRanges()
-	if err != nil {
-		log.Printf("Warning: Error fetching some data center ranges: %v", err)
+	if validate {
+		os.Exit(runValidate(cfg, os.Stdout))
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	blocklists := NewBlocklists(cfg)
+
+	// Cancelled on shutdown so in-flight downloads abort instead of holding
+	// up the exit
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Whatever the last run left on disk is served right away. The first
+	// downloads run in the background so they never hold up the servers,
+	// after which each list keeps itself up to date and retries soon after
+	// a failed download instead of a full interval later
+	updates := blocklists.updates()
+	if cfg.CacheDir != "" {
+		blocklists.loadCached(ctx, updates)
+	}
+	refresh := make([]refresher, len(updates))
+	for i := range updates {
+		refresh[i] = refresher{updates[i].source, make(chan refreshRequest, 1)}
+		go blocklists.periodicUpdate(ctx, updates[i], 0, refresh[i].requests)
 	}
-	dataCenterNetworks = dataCenterRanges
 
-	// Start the periodic update goroutine
-	go periodicUpdate()
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			slog.Info("Received SIGHUP, manual refresh of every list requested")
+			requestRefresh(refresh)
+		}
+	}()
 
-	dns.HandleFunc(".", handleRequest)
+	// Shared with DoH, so both see the same rate limits
+	handler := handleRequest(cfg, blocklists)
+	dns.Handle(".", handler)
 
-	server := &dns.Server{Addr: ":53", Net: "udp"}
-	log.Printf("Starting DNS server on port 53")
-	err = server.ListenAndServe()
-	if err != nil {
-		log.Fatalf("Failed to start server: %s\n", err.Error())
+	// UDP serves the bulk of queries, TCP lets resolvers retry truncated answers
+	servers := []*dns.Server{
+		{Addr: cfg.Listen, Net: "udp", WriteTimeout: dnsWriteTimeout},
+		{Addr: cfg.Listen, Net: "tcp", WriteTimeout: dnsWriteTimeout},
 	}
-}
 
-func periodicUpdate() {
-	retryDelay := initialRetryDelay
-	for {
-		time.Sleep(updateInterval)
-
-		updateFunctions := []struct {
-			name string
-			fn   func() error
-		}{
-			{"Firehol list", downloadAndParseFireholList},
-			{"Tor exit node list", downloadAndParseTorExitNodes},
-			{"IPsum list", downloadAndParseIpsumList},
-			{"Greensnow list", downloadAndParseGreensnowList},
-		}
-
-		for _, update := range updateFunctions {
-			if err := update.fn(); err != nil {
-				log.Printf("Failed to update %s: %v", update.name, err)
-				retryDelay = handleUpdateError(retryDelay)
-			} else {
-				log.Printf("Successfully updated %s", update.name)
-				retryDelay = initialRetryDelay
+	errChan := make(chan error, len(servers)+2)
+	for _, server := range servers {
+		go func(server *dns.Server) {
+			slog.Info("Starting DNS server", "addr", server.Addr, "net", server.Net)
+			if err := server.ListenAndServe(); err != nil {
+				errChan <- fmt.Errorf("%s: %w", server.Net, err)
 			}
-		}
-
-		dataCenterRanges, err := ip.GetDataCenterIPRanges()
-		if err != nil {
-			log.Printf("Warning: Error updating data center ranges: %v", err)
-			retryDelay = handleUpdateError(retryDelay)
-		} else {
-			networksMutex.Lock()
-			dataCenterNetworks = dataCenterRanges
-			networksMutex.Unlock()
-			log.Println("Successfully updated data center IP ranges")
-			retryDelay = initialRetryDelay
-		}
+		}(server)
 	}
-}
 
-func handleUpdateError(retryDelay time.Duration) time.Duration {
-	log.Printf("Will retry in %v", retryDelay)
-	time.Sleep(retryDelay)
-	retryDelay *= 2
-	if retryDelay > maxRetryDelay {
-		retryDelay = maxRetryDelay
+	var httpServer *http.Server
+	if cfg.HTTPListen != "" {
+		httpServer = newHTTPServer(cfg, blocklists, handler, refresh)
+		go func() {
+			slog.Info("Starting HTTP server", "addr", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("http: %w", err)
+			}
+		}()
 	}
-	return retryDelay
-}
 
-func downloadAndParseFireholList() error {
-	resp, err := http.Get(fireHolURL)
-	if err != nil {
-		return err
+	var pprofServer *http.Server
+	if cfg.PprofListen != "" {
+		pprofServer = newPprofServer(cfg.PprofListen)
+		go func() {
+			slog.Info("Starting pprof server", "addr", pprofServer.Addr)
+			if err := pprofServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("pprof: %w", err)
+			}
+		}()
 	}
-	defer resp.Body.Close()
 
-	var newBlockedNetworks []*net.IPNet
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+	exitCode := 0
+	select {
+	case sig := <-sigChan:
+		slog.Info("Shutting down", "signal", sig.String())
+	case err := <-errChan:
+		slog.Error("Failed to start server", "error", err)
+		exitCode = 1
+	}
 
-		_, ipNet, err := net.ParseCIDR(line)
-		if err != nil {
-			log.Printf("Error parsing CIDR %s: %v", line, err)
-			continue
+	cancel()
+	shutdownServers(servers)
+	if httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down HTTP server", "error", err)
 		}
-		newBlockedNetworks = append(newBlockedNetworks, ipNet)
+		cancel()
 	}
-
-	if err := scanner.Err(); err != nil {
-		return err
+	if pprofServer != nil {
+		// Nothing worth draining, a running CPU profile is just cut short
+		pprofServer.Close()
 	}
+	os.Exit(exitCode)
+}
 
-	networksMutex.Lock()
-	blockedNetworks = newBlockedNetworks
-	networksMutex.Unlock()
+func shutdownServers(servers []*dns.Server) {
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *dns.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(); err != nil {
+				slog.Error("Error shutting down DNS server", "net", server.Net, "error", err)
+			}
+		}(server)
+	}
+	wg.Wait()
+}
 
-	log.Printf("Loaded %d blocked networks", len(newBlockedNetworks))
-	return nil
+type listUpdate struct {
+	source string
+	name   string
+	fn     func(context.Context) error
 }
 
-func downloadAndParseTorExitNodes() error {
-	resp, err := http.Get(torExitNodeURL)
-	if err != nil {
-		return err
+// run performs the update once, recording and logging its outcome.
+func (b *Blocklists) run(ctx context.Context, u listUpdate) error {
+	start := time.Now()
+	stats := &ip.ParseStats{}
+	// Only the downloads of a successful update replace the copies on disk
+	writes := &ip.DiskWrites{}
+	defer writes.Discard()
+	// and only they are answered 304 next time
+	validators := &ip.PendingValidators{}
+	defer validators.Discard()
+	ctx = ip.WithPendingValidators(ip.WithDiskWrites(ip.WithParseStats(ctx, stats), writes), validators)
+	err := u.fn(ctx)
+	if ctx.Err() != nil {
+		// Shutting down, not a failure of the source
+		slog.Info("Abandoned list update", "source", u.source)
+		return ctx.Err()
 	}
-	defer resp.Body.Close()
-
-	var newTorExitNodes []net.IP
+	b.recordUpdate(u.source, time.Now(), err)
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+	// Unchanged lists parse nothing, their last counts still stand
+	parsed, skipped := stats.Counts()
+	if parsed+skipped > 0 {
+		recordParseMetrics(u.source, parsed, skipped)
+	}
 
-		ip := net.ParseIP(line)
-		if ip == nil {
-			log.Printf("Error parsing IP %s", line)
-			continue
+	if err == nil {
+		validators.Commit()
+		if err := writes.Commit(); err != nil {
+			slog.Warn("Failed to cache list on disk", "source", u.source, "error", err)
 		}
-		newTorExitNodes = append(newTorExitNodes, ip)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	durationMS := time.Since(start).Milliseconds()
+	if err != nil {
+		slog.Error("Failed to update list", "source", u.source, "duration_ms", durationMS, "parsed", parsed, "skipped", skipped, "error", err)
+	} else {
+		slog.Info("Updated list", "source", u.source, "duration_ms", durationMS, "parsed", parsed, "skipped", skipped)
 	}
-
-	networksMutex.Lock()
-	torExitNodes = newTorExitNodes
-	networksMutex.Unlock()
-
-	log.Printf("Loaded %d Tor exit nodes", len(newTorExitNodes))
-	return nil
+	return err
 }
 
-func downloadAndParseIpsumList() error {
-	resp, err := http.Get(ipsumURL)
-	if err != nil {
-		return err
+// fromURL binds a list download to its configured location.
+func fromURL(fn func(context.Context, string) error, url string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return fn(ctx, url)
 	}
-	defer resp.Body.Close()
+}
 
-	var newIpsumIPs []net.IP
+// updates returns the download for every configured list.
+func (b *Blocklists) updates() []listUpdate {
+	cfg, f := b.cfg, b.fetcher
+	builtin := []listUpdate{
+		b.sourceUpdate("Firehol list", ip.NewNetsetSource(f, "firehol", categoryFlagged, cfg.Sources.Firehol), func(_ ip.IPSet, networks *ip.PrefixTrie) {
+			b.swapFirehol(networks)
+		}),
+		b.sourceUpdate("Spamhaus DROP list", ip.NewSpamhausSource(f, categoryFlagged, cfg.Sources.Drop, cfg.Sources.Edrop), func(_ ip.IPSet, networks *ip.PrefixTrie) {
+			b.swap(func(l *lists) { l.drop = networks })
+		}),
+		b.sourceUpdate("Tor exit node list", ip.NewIPListSource(f, "tor", categoryTorExit, cfg.Sources.Tor), func(ips ip.IPSet, _ *ip.PrefixTrie) {
+			index := ip.NewSortedIPs(ips)
+			b.swap(func(l *lists) { l.torExit, l.torExitIndex = ips, index })
+		}),
+		b.sourceUpdate("IPsum list", ip.NewIpsumSource(f, categoryFlagged, cfg.Sources.Ipsum, cfg.IpsumMinScore), func(ips ip.IPSet, _ *ip.PrefixTrie) {
+			b.swapFlaggedIPs("IPsum", sourceIpsum, ips)
+		}),
+		b.sourceUpdate("Greensnow list", ip.NewIPListSource(f, "greensnow", categoryFlagged, cfg.Sources.Greensnow), func(ips ip.IPSet, _ *ip.PrefixTrie) {
+			b.swapFlaggedIPs("Greensnow", sourceGreensnow, ips)
+		}),
+		b.sourceUpdate("AbuseIPDB blacklist", ip.NewAbuseIPDBSource(f, categoryFlagged, cfg.AbuseIPDB.URL, cfg.AbuseIPDB.APIKey, cfg.AbuseIPDB.MinConfidence), func(ips ip.IPSet, _ *ip.PrefixTrie) {
+			b.swapFlaggedIPs("AbuseIPDB", sourceAbuseIPDB, ips)
+		}),
+		b.sourceUpdate("data center ranges", ip.NewDataCenterSource(f, categoryDataCenter), func(_ ip.IPSet, networks *ip.PrefixTrie) {
+			b.swap(func(l *lists) { l.dataCenter = networks })
+		}),
+		b.sourceUpdate("CDN ranges", ip.NewCDNSource(f, categoryCDN), func(_ ip.IPSet, networks *ip.PrefixTrie) {
+			b.swap(func(l *lists) { l.cdn = networks })
+		}),
+		b.sourceUpdate("proxy list", ip.NewNetsetSource(f, "proxy", categoryProxy, cfg.Sources.Proxy), func(_ ip.IPSet, networks *ip.PrefixTrie) {
+			b.swap(func(l *lists) { l.proxy = networks })
+		}),
+	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
+	// Disabled lists are never loaded, so they can't match any lookup
+	var updates []listUpdate
+	for _, update := range builtin {
+		if update.source == "abuseipdb" && cfg.AbuseIPDB.APIKey == "" {
 			continue
 		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 1 {
+		// Proxy lists vary too much to pick one by default
+		if update.source == "proxy" && cfg.Sources.Proxy == "" {
 			continue
 		}
-
-		ip := net.ParseIP(fields[0])
-		if ip == nil {
-			log.Printf("Error parsing IP %s", fields[0])
-			continue
+		if cfg.enabled(update.source) {
+			updates = append(updates, update)
+		} else {
+			slog.Info("Skipping source disabled in config", "source", update.source)
 		}
-		newIpsumIPs = append(newIpsumIPs, ip)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return err
+	if cfg.GeoIPDatabase != "" {
+		updates = append(updates, listUpdate{"geoip", "GeoIP database", fromURL(b.downloadAndParseGeoIP, cfg.GeoIPDatabase)})
+	}
+	if cfg.ASNDatabase != "" {
+		updates = append(updates, listUpdate{"asn", "ASN database", fromURL(b.downloadAndParseASN, cfg.ASNDatabase)})
+	}
+	if cfg.Allowlist != "" {
+		updates = append(updates, listUpdate{"allowlist", "allowlist", fromURL(b.downloadAndParseAllowlist, cfg.Allowlist)})
+	}
+	for _, feed := range cfg.Feeds {
+		updates = append(updates, b.sourceUpdate(feed.name(), ip.NewNetsetSource(f, feed.source(), feed.Label, feed.URL), b.storeFeed(feed)))
 	}
+	return updates
+}
 
-	networksMutex.Lock()
-	ipsumIPs = newIpsumIPs
-	networksMutex.Unlock()
+// runUpdates runs the updates concurrently and returns their errors in the
+// same order.
+func (b *Blocklists) runUpdates(ctx context.Context, updates []listUpdate) []error {
+	errs := make([]error, len(updates))
+
+	var wg sync.WaitGroup
+	for i, update := range updates {
+		wg.Add(1)
+		go func(i int, update listUpdate) {
+			defer wg.Done()
+			errs[i] = b.run(ctx, update)
+		}(i, update)
+	}
+	wg.Wait()
 
-	log.Printf("Loaded %d IPsum IPs", len(newIpsumIPs))
-	return nil
+	return errs
 }
 
-func downloadAndParseGreensnowList() error {
-	resp, err := http.Get(greensnowURL)
-	if err != nil {
-		return err
+// loadCached loads every list from the copies in the cache directory,
+// concurrently, without touching the network. Lists with no copy stay
+// empty until their first download.
+func (b *Blocklists) loadCached(ctx context.Context, updates []listUpdate) {
+	ctx = ip.WithCacheOnly(ctx)
+
+	var wg sync.WaitGroup
+	for _, update := range updates {
+		wg.Add(1)
+		go func(update listUpdate) {
+			defer wg.Done()
+			start := time.Now()
+			err := update.fn(ctx)
+			switch {
+			case errors.Is(err, ip.ErrNotCached):
+				slog.Info("No cached copy of list, waiting for the download", "source", update.source)
+			case err != nil:
+				slog.Warn("Failed to load cached list", "source", update.source, "error", err)
+			default:
+				slog.Info("Loaded list from disk", "source", update.source, "duration_ms", time.Since(start).Milliseconds())
+			}
+		}(update)
 	}
-	defer resp.Body.Close()
+	wg.Wait()
+}
 
-	var newGreensnowIPs []net.IP
+// requestRefresh wakes every periodicUpdate early. A list that already has
+// a refresh pending ignores the extra request.
+func requestRefresh(refresh []refresher) {
+	for _, r := range refresh {
+		select {
+		case r.requests <- nil:
+		default:
+		}
+	}
+}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+// periodicUpdate refreshes a single list forever, or sooner when woken
+// through refresh. Backoff is tracked per list so a flaky source doesn't
+// delay the healthy ones. Both paths run here, so a list is never
+// downloaded twice at once and the timer restarts after a manual refresh.
+func (b *Blocklists) periodicUpdate(ctx context.Context, update listUpdate, wait time.Duration, refresh <-chan refreshRequest) {
+	cfg := b.cfg
+	retryDelay := cfg.RetryDelay
+	for {
+		var done refreshRequest
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case done = <-refresh:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
 		}
 
-		ip := net.ParseIP(line)
-		if ip == nil {
-			log.Printf("Error parsing IP %s", line)
-			continue
+		err := b.run(ctx, update)
+		if done != nil {
+			done <- err
+		}
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			slog.Warn("Will retry failed update", "source", update.source, "retry_in", retryDelay.String())
+			wait = retryDelay
+			retryDelay = min(retryDelay*2, cfg.MaxRetryDelay)
+			b.recordNextUpdate(update.source, time.Time{})
+		} else {
+			wait = cfg.UpdateInterval
+			retryDelay = cfg.RetryDelay
+			b.recordNextUpdate(update.source, time.Now().Add(wait))
 		}
-		newGreensnowIPs = append(newGreensnowIPs, ip)
 	}
+}
+
+// lists is a point-in-time view of a Blocklists. A published lists is
+// never changed, so it is safe to read from any goroutine.
+type lists struct {
+	blocked    *ip.PrefixTrie
+	drop       *ip.PrefixTrie
+	dataCenter *ip.PrefixTrie
+	proxy      *ip.PrefixTrie
+	cdn        *ip.PrefixTrie
+	allowed    *ip.PrefixTrie
+	torExit    ip.IPSet
+	// flagged merges the exact-IP blocklists, keyed like ip.IPSet, so an
+	// address on several of them is stored once
+	flagged map[string]uint8
+	// flaggedIndex, by source bit, and torExitIndex hold the exact IPs in
+	// address order for network queries, see overlaps
+	flaggedIndex map[uint8]ip.SortedIPs
+	torExitIndex ip.SortedIPs
+	// custom is keyed by feed label
+	custom map[string]*ip.PrefixTrie
+	geo    *maxminddb.Reader
+	asn    *maxminddb.Reader
+	// allowedASNs are always SAFE, see allowedASN
+	allowedASNs asnList
+	feeds       feedList
+	priority    []string
+}
+
+func (l lists) isTorExitNode(ip net.IP) bool {
+	return l.torExit.Contains(ip)
+}
 
-	if err := scanner.Err(); err != nil {
-		return err
+// blockedMatches returns the blocklist entries containing addr, empty if it
+// isn't blocked at all. Exact-IP lists match as a single address network.
+func (l lists) blockedMatches(addr net.IP) []overlap {
+	var matches []overlap
+	if network, ok := l.blocked.Lookup(addr); ok {
+		matches = append(matches, overlap{categoryFlagged, "firehol", network})
 	}
+	if network, ok := l.drop.Lookup(addr); ok {
+		matches = append(matches, overlap{categoryFlagged, "drop", network})
+	}
+	for _, source := range flaggedSourceNames(l.flaggedSources(addr)) {
+		matches = append(matches, overlap{categoryFlagged, source, ip.HostNetwork(addr)})
+	}
+	return matches
+}
 
-	networksMutex.Lock()
-	greensnowIPs = newGreensnowIPs
-	networksMutex.Unlock()
+// dataCenterNetwork returns the data center range containing ip.
+func (l lists) dataCenterNetwork(ip net.IP) (*net.IPNet, bool) {
+	return l.dataCenter.Lookup(ip)
+}
 
-	log.Printf("Loaded %d Greensnow IPs", len(newGreensnowIPs))
-	return nil
+// proxyNetwork returns the residential proxy or VPN range containing ip.
+func (l lists) proxyNetwork(ip net.IP) (*net.IPNet, bool) {
+	return l.proxy.Lookup(ip)
 }
 
-func isTorExitNode(ip net.IP) bool {
-	networksMutex.RLock()
-	defer networksMutex.RUnlock()
+// cdnNetwork returns the CDN range containing ip.
+func (l lists) cdnNetwork(ip net.IP) (*net.IPNet, bool) {
+	return l.cdn.Lookup(ip)
+}
 
-	for _, exitNode := range torExitNodes {
-		if exitNode.Equal(ip) {
+// loaded reports whether any blocklist has entries.
+func (l lists) loaded() bool {
+	if l.blocked.Len() > 0 || l.drop.Len() > 0 || l.dataCenter.Len() > 0 || l.cdn.Len() > 0 || l.proxy.Len() > 0 ||
+		len(l.torExit) > 0 || len(l.flagged) > 0 {
+		return true
+	}
+	for _, trie := range l.custom {
+		if trie.Len() > 0 {
 			return true
 		}
 	}
 	return false
 }
 
-func isIPBlocked(ip net.IP) bool {
-	networksMutex.RLock()
-	defer networksMutex.RUnlock()
+// classification is the outcome of checking an IP against every list.
+// Labels pair each category with the source that produced it, e.g.
+// FLAGGED:ipsum, and Networks hold the list entry each matched, nil for
+// verdicts like the allowlist. Country is only set when a GeoIP database is
+// loaded.
+type classification struct {
+	Categories []string
+	Sources    []string
+	Labels     []string
+	Networks   []*net.IPNet
+	Country    string
+	// Score counts the sources that matched, see sourceScore
+	Score int
+}
 
-	for _, network := range blockedNetworks {
-		if network.Contains(ip) {
-			return true
-		}
+// safe reports whether c is a lone SAFE, allowlisted or not.
+func (c classification) safe() bool {
+	return len(c.Categories) == 1 && c.Categories[0] == categorySafe
+}
+
+func (c *classification) add(category, source string) {
+	c.addMatch(overlap{category, source, nil})
+}
+
+func (c *classification) addMatch(match overlap) {
+	if !slices.Contains(c.Categories, match.Category) {
+		c.Categories = append(c.Categories, match.Category)
 	}
+	c.Sources = append(c.Sources, match.Source)
+	c.Labels = append(c.Labels, match.Category+":"+match.Source)
+	c.Networks = append(c.Networks, match.Network)
+}
 
-	for _, blockedIP := range ipsumIPs {
-		if ip.Equal(blockedIP) {
-			return true
+// matchedCIDR is the list entry behind the highest priority label, "" when
+// it came from no list entry.
+func (c classification) matchedCIDR() string {
+	for _, network := range c.Networks {
+		if network != nil {
+			return network.String()
 		}
 	}
+	return ""
+}
 
-	for _, blockedIP := range greensnowIPs {
-		if ip.Equal(blockedIP) {
-			return true
+// matchStrings describes the list entry each label matched, in the
+// "CATEGORY:source network" form of network queries.
+func (c classification) matchStrings() []string {
+	var txt []string
+	for i, network := range c.Networks {
+		if network != nil {
+			txt = append(txt, c.Labels[i]+" "+network.String())
 		}
 	}
+	return txt
+}
 
-	return false
+// classify is Blocklists.Classify against a snapshot, so a batch of
+// lookups sees the same lists throughout.
+//
+// The allowlist takes precedence over everything else: an allowed IP, or
+// one announced by an allowlisted AS, is SAFE even if it also appears on a
+// blocklist, data center or Tor list.
+// Private and other reserved addresses come next and skip the lists.
+func (l lists) classify(addr net.IP) classification {
+	result := classification{Country: l.country(addr)}
+	if l.isAllowed(addr) {
+		result.add(categorySafe, "allowlist")
+		return result
+	}
+	if asn, ok := l.allowedASN(addr); ok {
+		result.add(categorySafe, "AS"+strconv.FormatUint(uint64(asn), 10))
+		return result
+	}
+
+	// Internal addresses are never on public lists, calling them SAFE
+	// would suggest they were checked
+	if addr.IsPrivate() {
+		result.add(categoryPrivate, "iana")
+		return result
+	}
+	if ip.IsReserved(addr) {
+		result.add(categoryReserved, "iana")
+		return result
+	}
+
+	for _, match := range l.blockedMatches(addr) {
+		result.addMatch(match)
+	}
+	if network, ok := l.dataCenterNetwork(addr); ok {
+		result.addMatch(overlap{categoryDataCenter, "datacenter", network})
+	}
+	if l.isTorExitNode(addr) {
+		result.addMatch(overlap{categoryTorExit, "tor", ip.HostNetwork(addr)})
+	}
+	if network, ok := l.proxyNetwork(addr); ok {
+		result.addMatch(overlap{categoryProxy, "proxy", network})
+	}
+	if network, ok := l.cdnNetwork(addr); ok {
+		result.addMatch(overlap{categoryCDN, "cloudflare", network})
+	}
+	for _, match := range l.customFeedMatches(addr) {
+		result.addMatch(match)
+	}
+	result.Score = sourceScore(result.Sources)
+
+	if len(result.Categories) == 0 {
+		result.Categories = []string{categorySafe}
+	}
+	return result.prioritized(l.priority)
 }
 
-func isDataCenterIP(ip net.IP) bool {
-	networksMutex.RLock()
-	defer networksMutex.RUnlock()
+// txtStrings lists the bare categories first, so clients reading only the
+// first string keep working, followed by the CATEGORY:source labels.
+func (c classification) txtStrings() []string {
+	txt := append(slices.Clone(c.Categories), c.Labels...)
+	if c.Country != "" {
+		txt = append(txt, "CC:"+c.Country)
+	}
+	return txt
+}
 
-	for _, network := range dataCenterNetworks {
-		if network.Contains(ip) {
-			return true
+// dnsWriteTimeout bounds writing an answer. queryTimeout bounds working
+// the answer out before that, leaving the rest of the write timeout to send
+// a SERVFAIL when it runs out.
+const (
+	dnsWriteTimeout = 2 * time.Second
+	queryTimeout    = dnsWriteTimeout / 2
+)
+
+// handleRequest answers TXT and A questions about the IP encoded in the
+// question name.
+func handleRequest(cfg *Config, blocklists *Blocklists) dns.HandlerFunc {
+	limiter := newRateLimiter(cfg.RateLimit)
+	// Metrics are only served with the HTTP server, skip the clock reads
+	// otherwise
+	timed := cfg.HTTPListen != ""
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		dnsQueries.Inc()
+		if timed {
+			start := time.Now()
+			defer func() { queryDuration.Observe(time.Since(start).Seconds()) }()
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		if !limiter.allow(w.RemoteAddr()) {
+			rateLimited.Inc()
+			m.Rcode = dns.RcodeRefused
+			writeResponse(w, r, m)
+			return
+		}
+
+		// Answered aside, so a query stuck past its deadline still gets a
+		// SERVFAIL in time rather than no answer at all
+		done := make(chan struct{})
+		go func(m *dns.Msg) {
+			answerQuery(cfg, blocklists, w.RemoteAddr(), r, m)
+			close(done)
+		}(m)
+
+		timer := time.NewTimer(queryTimeout)
+		select {
+		case <-done:
+			timer.Stop()
+		case <-timer.C:
+			queryTimeouts.Inc()
+			slog.Warn("Query timed out", "client", w.RemoteAddr().String(), "timeout", queryTimeout.String())
+			m = new(dns.Msg)
+			m.SetRcode(r, dns.RcodeServerFailure)
 		}
+
+		writeResponse(w, r, m)
 	}
-	return false
 }
 
-func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Compress = false
-
-	if r.Opcode == dns.OpcodeQuery {
+// answerQuery fills in m, the reply to r from client.
+func answerQuery(cfg *Config, blocklists *Blocklists, client net.Addr, r, m *dns.Msg) {
+	if r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+	} else if len(r.Question) != 1 {
+		// Like most servers only single question messages are answered,
+		// SetReply already dropped any further questions
+		m.Rcode = dns.RcodeFormatError
+	} else {
 		for _, q := range m.Question {
-			switch q.Qtype {
-			case dns.TypeTXT:
-				name := strings.TrimSuffix(q.Name, ".")
-				ip := net.ParseIP(name)
+			// Only TXT and A carry a classification, and PTR when enabled
+			if q.Qtype != dns.TypeTXT && q.Qtype != dns.TypeA && (q.Qtype != dns.TypePTR || cfg.PTRDomain == "") {
+				m.Rcode = dns.RcodeRefused
+				continue
+			}
 
-				if ip == nil {
-					continue
+			if isStatusName(q.Name, cfg.StatusName) {
+				if q.Qtype == dns.TypeTXT {
+					// TTL 0, the status is only useful fresh
+					m.Answer = append(m.Answer, &dns.TXT{
+						Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+						Txt: blocklists.statusStrings(),
+					})
 				}
+				continue
+			}
 
-				var txt string
-				if isIPBlocked(ip) {
-					txt = "FLAGGED"
-				} else if isDataCenterIP(ip) {
-					txt = "DATACENTER"
-				} else if isTorExitNode(ip) {
-					txt = "TOR_EXIT"
-				} else {
-					txt = "SAFE"
+			// Before any list has loaded every address would come back
+			// SAFE, so fail closed unless told otherwise
+			if !cfg.FailOpen && !blocklists.Loaded() {
+				notReady.Inc()
+				m.Rcode = dns.RcodeServerFailure
+				continue
+			}
+
+			var result classification
+			var matches []overlap
+			var addr net.IP
+			if network, ok := parseQueryCIDR(q.Name); ok {
+				result, matches = blocklists.Overlaps(network)
+			} else {
+				var err error
+				if addr, err = parseQueryName(q.Name, cfg.Zone); err != nil {
+					m.Rcode = dns.RcodeFormatError
+					continue
+				}
+				result = blocklists.Classify(addr)
+			}
+			if cfg.SingleCategory {
+				result = result.top()
+			}
+			blocklists.queries.record(addr, result.Categories)
+			slog.Debug("Answered query", "client", client.String(), "name", q.Name,
+				"type", dns.TypeToString[q.Qtype], "categories", result.Categories)
+			for _, category := range result.Categories {
+				responses.WithLabelValues(category).Inc()
+			}
+			if result.safe() && cfg.SafeAnswer != safeAnswerTXT {
+				// Every query type, so A lookups agree with TXT ones
+				if cfg.SafeAnswer == safeAnswerNXDomain {
+					m.Rcode = dns.RcodeNameError
 				}
+				continue
+			}
+			ttl := blocklists.answerTTL(time.Now(), result.Categories[0])
 
+			switch q.Qtype {
+			case dns.TypeTXT:
 				rr := &dns.TXT{
-					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: cacheTTL},
-					Txt: []string{txt},
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+					Txt: result.txtStrings(),
+				}
+				if cfg.TXTMatchedCIDR {
+					rr.Txt = append(rr.Txt, result.matchStrings()...)
+				}
+				if cfg.TXTScore {
+					rr.Txt = append(rr.Txt, "SCORE:"+strconv.Itoa(result.Score))
+				}
+				rr.Txt = append(rr.Txt, overlapStrings(result, matches)...)
+				if blocklists.degraded() {
+					rr.Txt = append(rr.Txt, "STALE")
 				}
 				m.Answer = append(m.Answer, rr)
+			case dns.TypeA:
+				// SAFE has no return code, so clean IPs get an empty answer
+				for _, code := range returnCodesFor(result.Categories, cfg.BlockCategories) {
+					rr := &dns.A{
+						Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+						A:   code,
+					}
+					m.Answer = append(m.Answer, rr)
+				}
+			case dns.TypePTR:
+				m.Answer = append(m.Answer, &dns.PTR{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+					Ptr: ptrName(result.Categories[0], cfg.PTRDomain),
+				})
 			}
 		}
 	}
-
-	w.WriteMsg(m)
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// testResponseWriter records the reply handleRequest writes, without a
+// socket behind it.
+type testResponseWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func newTestResponseWriter() *testResponseWriter {
+	return &testResponseWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}}
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *testResponseWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *testResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testResponseWriter) Close() error                { return nil }
+func (w *testResponseWriter) TsigStatus() error           { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool)         {}
+func (w *testResponseWriter) Hijack()                     {}
+
+// newTestBlocklists returns lists for cfg filled in by update, as if they
+// had just been downloaded.
+func newTestBlocklists(cfg *Config, update func(*lists)) *Blocklists {
+	b := NewBlocklists(cfg)
+	b.swap(update)
+	return b
+}
+
+// testLists fills every built-in list with a few IPv4 and IPv6 entries.
+func testLists(t testing.TB) func(*lists) {
+	return func(l *lists) {
+		l.blocked = ip.NewPrefixTrie(mustParseCIDRs(t, "45.0.0.0/16", "2a0b:4340::/32"))
+		l.dataCenter = ip.NewPrefixTrie(mustParseCIDRs(t, "34.64.0.0/10", "2600:1900::/28"))
+		l.torExit = ipSet("185.220.101.1", "2a0b:f4c2::1")
+		l.flagged = map[string]uint8{
+			string(net.ParseIP("5.188.10.1").To16()):     sourceIpsum,
+			string(net.ParseIP("2a01:4f8::dead").To16()): sourceGreensnow,
+		}
+	}
+}
+
+// exchange sends a question for name through handleRequest.
+func exchange(t testing.TB, handler dns.HandlerFunc, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	w := newTestResponseWriter()
+	handler(w, r)
+	if w.msg == nil {
+		t.Fatalf("no reply to %s", name)
+	}
+	return w.msg
+}
+
+// txtCategories returns the bare categories leading a TXT reply.
+func txtCategories(m *dns.Msg) string {
+	if len(m.Answer) == 0 {
+		return ""
+	}
+	var categories []string
+	for _, s := range m.Answer[0].(*dns.TXT).Txt {
+		if strings.ContainsAny(s, ": ") {
+			break
+		}
+		categories = append(categories, s)
+	}
+	return strings.Join(categories, ",")
+}
+
+func TestIPv6Lookups(t *testing.T) {
+	b := newTestBlocklists(defaultConfig(), testLists(t))
+
+	tests := []struct {
+		addr       string
+		blocked    bool
+		dataCenter bool
+		tor        bool
+	}{
+		{"2a0b:4340::1", true, false, false},
+		{"2a0b:4340:ffff:ffff:ffff:ffff:ffff:ffff", true, false, false},
+		{"2a0b:4341::1", false, false, false},
+		{"2a01:4f8::dead", true, false, false},
+		{"2a01:4f8::beef", false, false, false},
+		{"2600:190f::1", false, true, false},
+		{"2600:1910::1", false, false, false},
+		{"2a0b:f4c2::1", false, false, true},
+		// The IPv4 entries must not match their IPv6 look-alikes
+		{"::2d00:1", false, false, false},
+		{"2d00::1", false, false, false},
+	}
+	for _, tt := range tests {
+		addr := net.ParseIP(tt.addr)
+		if got := b.IsBlocked(addr); got != tt.blocked {
+			t.Errorf("IsBlocked(%s) = %v, want %v", tt.addr, got, tt.blocked)
+		}
+		if got := b.IsDataCenter(addr); got != tt.dataCenter {
+			t.Errorf("IsDataCenter(%s) = %v, want %v", tt.addr, got, tt.dataCenter)
+		}
+		if got := b.IsTorExit(addr); got != tt.tor {
+			t.Errorf("IsTorExit(%s) = %v, want %v", tt.addr, got, tt.tor)
+		}
+	}
+}
+
+func TestHandleRequestIPv6(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Zone = "bl.example.com"
+	handler := handleRequest(cfg, newTestBlocklists(cfg, testLists(t)))
+
+	// inZone rewrites an ip6.arpa name to the DNSBL zone
+	inZone := func(addr string) string {
+		reverse, _ := dns.ReverseAddr(addr)
+		return strings.TrimSuffix(reverse, "ip6.arpa.") + cfg.Zone
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"2a0b:4340::1", categoryFlagged},
+		{"2A0B:4340::1", categoryFlagged},
+		{"2a01:4f8::dead", categoryFlagged},
+		{"2600:1900::1", categoryDataCenter},
+		{"2a0b:f4c2::1", categoryTorExit},
+		{"2a0b:4341::1", categorySafe},
+		{inZone("2a0b:4340::1"), categoryFlagged},
+		{inZone("2600:1900::1"), categoryDataCenter},
+		{inZone("2a0b:4341::1"), categorySafe},
+		{mustReverseAddr(t, "2a0b:f4c2::1"), categoryTorExit},
+		{mustReverseAddr(t, "2a01:4f8::dead"), categoryFlagged},
+		// IPv4-mapped addresses are answered as the IPv4 address
+		{"::ffff:45.0.0.1", categoryFlagged},
+		{"::1", categoryReserved},
+		{"fd00::1", categoryPrivate},
+	}
+	for _, tt := range tests {
+		m := exchange(t, handler, tt.name, dns.TypeTXT)
+		if m.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: rcode %s, want NOERROR", tt.name, dns.RcodeToString[m.Rcode])
+			continue
+		}
+		if got := txtCategories(m); got != tt.want {
+			t.Errorf("%s: TXT %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	m := exchange(t, handler, "2a0b:4340::1", dns.TypeA)
+	if len(m.Answer) != 1 || !m.Answer[0].(*dns.A).A.Equal(returnCodes[categoryFlagged]) {
+		t.Errorf("A 2a0b:4340::1 = %v, want %s", m.Answer, returnCodes[categoryFlagged])
+	}
+
+	for _, name := range []string{
+		"2a0b:4340::1::1",
+		strings.Replace(mustReverseAddr(t, "2a0b:4340::1"), "0.", "00.", 1),
+		strings.Replace(mustReverseAddr(t, "2a0b:4340::1"), "0.", "g.", 1),
+		strings.Replace(inZone("2a0b:4340::1"), "0.", "", 1),
+	} {
+		if m := exchange(t, handler, name, dns.TypeTXT); m.Rcode != dns.RcodeFormatError {
+			t.Errorf("%s: rcode %s, want FORMERR", name, dns.RcodeToString[m.Rcode])
+		}
+	}
+}
+
+func mustReverseAddr(t testing.TB, addr string) string {
+	t.Helper()
+	reverse, err := dns.ReverseAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reverse
+}
+
+func TestBlockedMatchesHostNetwork(t *testing.T) {
+	l := lists{flagged: map[string]uint8{string(net.ParseIP("5.188.10.1").To16()): sourceIpsum}}
+	for _, addr := range []net.IP{net.ParseIP("5.188.10.1"), net.ParseIP("5.188.10.1").To4()} {
+		matches := l.blockedMatches(addr)
+		if len(matches) != 1 || matches[0].Network.String() != "5.188.10.1/32" {
+			t.Errorf("blockedMatches(%d byte 5.188.10.1) = %v, want FLAGGED:ipsum 5.188.10.1/32", len(addr), matches)
+		}
+	}
+}
+
+func TestClassifyAddressForms(t *testing.T) {
+	b := newTestBlocklists(defaultConfig(), testLists(t))
+
+	for _, addr := range []string{"45.0.0.1", "34.64.0.1", "185.220.101.1", "5.188.10.1", "8.8.8.8", "10.0.0.1"} {
+		v4 := b.Classify(net.ParseIP(addr).To4())
+		for _, form := range []net.IP{net.ParseIP(addr), net.ParseIP("::ffff:" + addr)} {
+			got := b.snapshot().classify(form)
+			if strings.Join(got.Labels, ",") != strings.Join(v4.Labels, ",") ||
+				strings.Join(got.matchStrings(), ",") != strings.Join(v4.matchStrings(), ",") {
+				t.Errorf("classify(%d byte %s) = %v %v, want %v %v as for 4 bytes",
+					len(form), form, got.Labels, got.matchStrings(), v4.Labels, v4.matchStrings())
+			}
+		}
+	}
+}
+
+func TestHandleRequestQuestionCount(t *testing.T) {
+	cfg := defaultConfig()
+	handler := handleRequest(cfg, newTestBlocklists(cfg, testLists(t)))
+
+	tests := []struct {
+		name      string
+		questions []dns.Question
+	}{
+		{"none", nil},
+		{"two", []dns.Question{
+			{Name: "45.0.0.1.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET},
+			{Name: "8.8.8.8.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET},
+		}},
+		{"same twice", []dns.Question{
+			{Name: "45.0.0.1.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "45.0.0.1.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		}},
+	}
+	for _, tt := range tests {
+		r := &dns.Msg{Question: tt.questions}
+		r.Id = dns.Id()
+		w := newTestResponseWriter()
+		handler(w, r)
+
+		if w.msg == nil || w.msg.Rcode != dns.RcodeFormatError {
+			t.Errorf("%s: reply %v, want FORMERR", tt.name, w.msg)
+			continue
+		}
+		if len(w.msg.Answer) != 0 {
+			t.Errorf("%s: FORMERR carries %d answers, want none", tt.name, len(w.msg.Answer))
+		}
+		if _, err := w.msg.Pack(); err != nil {
+			t.Errorf("%s: reply doesn't pack: %v", tt.name, err)
+		}
+	}
+}
+
+// BenchmarkHandleRequest answers TXT queries end to end, through the rate
+// limiter, cache and reply packing, without a socket.
+func BenchmarkHandleRequest(b *testing.B) {
+	cfg := defaultConfig()
+	cfg.RateLimit = RateLimitConfig{ClientQPS: 1e9, ClientBurst: 1e9}
+	handler := handleRequest(cfg, newTestBlocklists(cfg, syntheticLists(10_000)))
+
+	queries := syntheticQueries(1024)
+	msgs := make([]*dns.Msg, len(queries))
+	for i, addr := range queries {
+		msgs[i] = new(dns.Msg)
+		msgs[i].SetQuestion(dns.Fqdn(addr.String()), dns.TypeTXT)
+	}
+	w := newTestResponseWriter()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler(w, msgs[i%len(msgs)])
+		if _, err := w.msg.Pack(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClassifyPrivateAndReserved(t *testing.T) {
+	// Listed everywhere, private and reserved addresses still skip the lists
+	b := newTestBlocklists(defaultConfig(), func(l *lists) {
+		everything := ip.NewPrefixTrie(mustParseCIDRs(t, "0.0.0.0/0", "::/0"))
+		l.blocked, l.dataCenter = everything, everything
+	})
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.0.0.0", categoryPrivate},
+		{"10.255.255.255", categoryPrivate},
+		{"172.16.0.1", categoryPrivate},
+		{"192.168.1.1", categoryPrivate},
+		{"fd12:3456::1", categoryPrivate},
+		{"127.0.0.1", categoryReserved},
+		{"169.254.169.254", categoryReserved},
+		{"100.64.0.1", categoryReserved},
+		{"fe80::1", categoryReserved},
+		{"febf:ffff:ffff:ffff::1", categoryReserved},
+		{"::1", categoryReserved},
+		{"::ffff:10.0.0.1", categoryPrivate},
+		{"11.0.0.1", categoryFlagged},
+		{"fec0::1", categoryFlagged},
+	}
+	for _, tt := range tests {
+		result := b.Classify(ip.Canonical(net.ParseIP(tt.addr)))
+		if result.Categories[0] != tt.want {
+			t.Errorf("Classify(%s) = %v, want %s first", tt.addr, result.Labels, tt.want)
+		}
+		if tt.want != categoryFlagged && (len(result.Labels) != 1 || result.Labels[0] != tt.want+":iana") {
+			t.Errorf("Classify(%s) = %v, want only %s:iana", tt.addr, result.Labels, tt.want)
+		}
+	}
+}
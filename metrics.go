@@ -0,0 +1,103 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dnsQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipshield_dns_queries_total",
+		Help: "Total DNS queries received.",
+	})
+	rateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipshield_dns_rate_limited_total",
+		Help: "DNS queries refused by the rate limiter.",
+	})
+	queryTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipshield_dns_query_timeouts_total",
+		Help: "DNS queries answered SERVFAIL because working out the answer took too long.",
+	})
+	notReady = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipshield_not_ready_total",
+		Help: "DNS queries and HTTP lookups failed because no list had loaded yet.",
+	})
+	queryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "ipshield_dns_query_duration_seconds",
+		Help: "Time spent handling a DNS query, from receipt until the answer is written.",
+		// 10µs up to about 0.7s
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 9),
+	})
+	responses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipshield_responses_total",
+		Help: "Classifications answered, by category.",
+	}, []string{"category"})
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipshield_cache_lookups_total",
+		Help: "Classification cache lookups, by cache (positive or negative) and result (hit or miss).",
+	}, []string{"cache", "result"})
+	listEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_entries",
+		Help: "Entries loaded from each source.",
+	}, []string{"source"})
+	listLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful update of each source.",
+	}, []string{"source"})
+	listParsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_parsed_entries",
+		Help: "Entries parsed from the latest download of each source, before filtering and deduplication.",
+	}, []string{"source"})
+	listSkipped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_skipped_lines",
+		Help: "Malformed lines skipped in the latest download of each source.",
+	}, []string{"source"})
+	listBelowMinEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_below_min_entries",
+		Help: "1 while the list in use has fewer entries than the min_entries configured for its source.",
+	}, []string{"source"})
+	listCappedEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_capped_entries",
+		Help: "Entries dropped from the latest download of each source to keep its category within max_entries.",
+	}, []string{"source"})
+	listFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipshield_list_download_failures_total",
+		Help: "Failed downloads of each source.",
+	}, []string{"source"})
+	listConsecutiveFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_consecutive_failures",
+		Help: "Failed downloads of each source since its last success.",
+	}, []string{"source"})
+	listStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_stale",
+		Help: "1 while a source's last successful update is older than its maximum age.",
+	}, []string{"source"})
+	listFailing = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipshield_list_failing",
+		Help: "1 while a source has been failing for longer than -failing-intervals update intervals.",
+	}, []string{"source"})
+)
+
+// recordUpdateMetrics publishes the outcome of an update, failures being
+// the consecutive failures so far.
+func recordUpdateMetrics(source string, at time.Time, failures int, failing bool) {
+	listConsecutiveFailures.WithLabelValues(source).Set(float64(failures))
+	listFailing.WithLabelValues(source).Set(0)
+	if failing {
+		listFailing.WithLabelValues(source).Set(1)
+	}
+
+	if failures > 0 {
+		listFailures.WithLabelValues(source).Inc()
+		return
+	}
+	listLastSuccess.WithLabelValues(source).Set(float64(at.Unix()))
+}
+
+// recordParseMetrics publishes the line counts of the latest download.
+func recordParseMetrics(source string, parsed, skipped int64) {
+	listParsed.WithLabelValues(source).Set(float64(parsed))
+	listSkipped.WithLabelValues(source).Set(float64(skipped))
+}
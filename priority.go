@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// defaultCategoryPriority is the order categories are reported in unless the
+// config says otherwise.
+var defaultCategoryPriority = []string{categoryFlagged, categoryDataCenter, categoryTorExit, categoryProxy, categoryCDN}
+
+// categoryRank places category in priority, most important first.
+// Categories it doesn't list, such as custom feed labels, follow in the
+// order they matched.
+func categoryRank(priority []string, category string) int {
+	if i := slices.Index(priority, category); i >= 0 {
+		return i
+	}
+	return len(priority)
+}
+
+func labelCategory(label string) string {
+	category, _, _ := strings.Cut(label, ":")
+	return category
+}
+
+// prioritized returns c with its categories in priority order and the
+// labels and sources regrouped to match.
+func (c classification) prioritized(priority []string) classification {
+	categories := slices.Clone(c.Categories)
+	slices.SortStableFunc(categories, func(a, b string) int {
+		return categoryRank(priority, a) - categoryRank(priority, b)
+	})
+	return c.only(categories)
+}
+
+// top keeps just the highest priority category, for answers that carry a
+// single verdict.
+func (c classification) top() classification {
+	if len(c.Categories) <= 1 {
+		return c
+	}
+	return c.only(c.Categories[:1])
+}
+
+func (c classification) only(categories []string) classification {
+	result := classification{Categories: categories, Country: c.Country, Score: c.Score}
+	for _, category := range categories {
+		for i, label := range c.Labels {
+			if labelCategory(label) == category {
+				result.Sources = append(result.Sources, c.Sources[i])
+				result.Labels = append(result.Labels, label)
+				result.Networks = append(result.Networks, c.Networks[i])
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+const (
+	ip6ArpaSuffix    = ".ip6.arpa"
+	inAddrArpaSuffix = ".in-addr.arpa"
+)
+
+var (
+	errUnknownQueryName   = errors.New("query name is not an IP address")
+	errMalformedQueryName = errors.New("malformed reverse query name")
+)
+
+// parseQueryName extracts the IP being asked about from a question name.
+// Accepted forms are the IP itself (1.2.3.4 or 2001:db8::1), the DNSBL form
+// (4.3.2.1.zone or b.a.9.8...zone) and the in-addr.arpa and ip6.arpa
+// reverse forms.
+//
+// zone is the DNSBL zone appended to reverse-octet queries. IPv6 addresses
+// use the same 32 nibble labels as ip6.arpa (RFC 5782). Empty disables the
+// DNSBL form.
+func parseQueryName(name, zone string) (net.IP, error) {
+	addr, err := parseQueryNameForm(name, zone)
+	if err != nil {
+		return nil, err
+	}
+	return ip.Canonical(addr), nil
+}
+
+func parseQueryNameForm(name, zone string) (net.IP, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	if ip := net.ParseIP(name); ip != nil {
+		return ip, nil
+	}
+
+	if hasSuffixFold(name, ip6ArpaSuffix) {
+		ip := parseIP6Arpa(name[:len(name)-len(ip6ArpaSuffix)])
+		if ip == nil {
+			return nil, errMalformedQueryName
+		}
+		return ip, nil
+	}
+
+	if hasSuffixFold(name, inAddrArpaSuffix) {
+		ip := parseReversedIPv4(name[:len(name)-len(inAddrArpaSuffix)])
+		if ip == nil {
+			return nil, errMalformedQueryName
+		}
+		return ip, nil
+	}
+
+	if zone == "" {
+		return nil, errUnknownQueryName
+	}
+
+	suffix := "." + zone
+	if !hasSuffixFold(name, suffix) {
+		return nil, errUnknownQueryName
+	}
+
+	reversed := name[:len(name)-len(suffix)]
+	if ip := parseReversedIPv4(reversed); ip != nil {
+		return ip, nil
+	}
+	if ip := parseIP6Arpa(reversed); ip != nil {
+		return ip, nil
+	}
+	return nil, errUnknownQueryName
+}
+
+func hasSuffixFold(name, suffix string) bool {
+	return len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix)
+}
+
+func parseReversedIPv4(name string) net.IP {
+	octets := strings.Split(name, ".")
+	if len(octets) != net.IPv4len {
+		return nil
+	}
+
+	var b [net.IPv4len]byte
+	for i, octet := range octets {
+		n, err := strconv.ParseUint(octet, 10, 8)
+		if err != nil {
+			return nil
+		}
+		b[net.IPv4len-1-i] = byte(n)
+	}
+
+	return net.IPv4(b[0], b[1], b[2], b[3])
+}
+
+// parseIP6Arpa rebuilds an address from its 32 reversed nibble labels,
+// returning nil unless every label is a single hex digit.
+func parseIP6Arpa(name string) net.IP {
+	nibbles := strings.Split(name, ".")
+	if len(nibbles) != 2*net.IPv6len {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i, nibble := range nibbles {
+		if len(nibble) != 1 {
+			return nil
+		}
+		n, err := strconv.ParseUint(nibble, 16, 4)
+		if err != nil {
+			return nil
+		}
+
+		// The first label is the low nibble of the last byte
+		pos := len(nibbles) - 1 - i
+		if pos%2 == 0 {
+			ip[pos/2] |= byte(n) << 4
+		} else {
+			ip[pos/2] |= byte(n)
+		}
+	}
+
+	return ip
+}
+
+// ptrName is the synthetic host name PTR answers point to for category,
+// e.g. tor-exit.ipshield. for TOR_EXIT under domain ipshield.
+func ptrName(category, domain string) string {
+	label := strings.ReplaceAll(strings.ToLower(category), "_", "-")
+	return dns.Fqdn(label + "." + domain)
+}
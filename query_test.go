@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func FuzzParseQueryName(f *testing.F) {
+	for _, seed := range []string{
+		"45.0.0.1.",
+		"2a0b:4340::1.",
+		"1.0.0.45.bl.example.com.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.4.3.4.b.0.a.2.bl.example.com.",
+		"1.101.220.185.in-addr.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.c.4.f.b.0.a.2.ip6.arpa.",
+		"45.0.0.0/16.",
+		"2600:1900::/28.",
+		"::ffff:5.188.10.1.",
+		"256.0.0.1.in-addr.arpa.",
+		"g.ip6.arpa.",
+		"bl.example.com.",
+	} {
+		f.Add(seed)
+	}
+
+	cfg := defaultConfig()
+	cfg.Zone = "bl.example.com"
+	cfg.PTRDomain = "ipshield"
+	b := newTestBlocklists(cfg, testLists(f))
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if addr, err := parseQueryName(name, cfg.Zone); err == nil && len(addr) != net.IPv4len && len(addr) != net.IPv6len {
+			t.Fatalf("parseQueryName(%q) = %v, %d bytes", name, addr, len(addr))
+		}
+
+		for _, qtype := range []uint16{dns.TypeTXT, dns.TypeA, dns.TypePTR} {
+			r := new(dns.Msg)
+			r.SetQuestion(name, qtype)
+			// Only names that can arrive on the wire reach answerQuery
+			if _, err := r.Pack(); err != nil {
+				return
+			}
+
+			m := new(dns.Msg)
+			m.SetReply(r)
+			answerQuery(cfg, b, client, r, m)
+			if _, err := m.Pack(); err != nil {
+				t.Fatalf("reply to %q %s doesn't pack: %v", name, dns.TypeToString[qtype], err)
+			}
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"container/heap"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// queryStats counts answered DNS queries by category, and the most queried
+// IPs with the Space-Saving algorithm: at most size IPs are tracked, and an
+// IP seen for the first time takes over the counter of the least queried
+// one. Frequent IPs stay on the list, their counts overestimated by at most
+// the count they took over. A nil *queryStats counts nothing.
+type queryStats struct {
+	mu         sync.Mutex
+	size       int
+	categories map[string]uint64
+	ips        map[string]*ipCount
+	// least keeps the tracked IPs ordered by count, least queried first
+	least ipCountHeap
+}
+
+type ipCount struct {
+	ip    string
+	count uint64
+	// error is the count taken over from the IP this one replaced
+	error uint64
+	index int
+}
+
+func newQueryStats(size int) *queryStats {
+	if size <= 0 {
+		return nil
+	}
+	return &queryStats{
+		size:       size,
+		categories: make(map[string]uint64),
+		ips:        make(map[string]*ipCount, size),
+	}
+}
+
+// record counts a query for ip, nil for network queries, answered with
+// categories.
+func (s *queryStats) record(ip net.IP, categories []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, category := range categories {
+		s.categories[category]++
+	}
+	if ip == nil {
+		return
+	}
+
+	key := ip.String()
+	if counter, ok := s.ips[key]; ok {
+		counter.count++
+		heap.Fix(&s.least, counter.index)
+		return
+	}
+	if len(s.least) < s.size {
+		counter := &ipCount{ip: key, count: 1}
+		s.ips[key] = counter
+		heap.Push(&s.least, counter)
+		return
+	}
+
+	counter := s.least[0]
+	delete(s.ips, counter.ip)
+	counter.ip, counter.error = key, counter.count
+	counter.count++
+	s.ips[key] = counter
+	heap.Fix(&s.least, 0)
+}
+
+type topIP struct {
+	IP    string `json:"ip"`
+	Count uint64 `json:"count"`
+	// Error bounds how much Count may overstate the real number
+	Error uint64 `json:"error"`
+}
+
+type queryStatsResponse struct {
+	Categories map[string]uint64 `json:"categories"`
+	TopIPs     []topIP           `json:"top_ips"`
+}
+
+func (s *queryStats) snapshot() queryStatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := queryStatsResponse{
+		Categories: make(map[string]uint64, len(s.categories)),
+		TopIPs:     make([]topIP, 0, len(s.least)),
+	}
+	for category, n := range s.categories {
+		resp.Categories[category] = n
+	}
+	for _, counter := range s.least {
+		resp.TopIPs = append(resp.TopIPs, topIP{counter.ip, counter.count, counter.error})
+	}
+	sort.Slice(resp.TopIPs, func(i, j int) bool {
+		if resp.TopIPs[i].Count != resp.TopIPs[j].Count {
+			return resp.TopIPs[i].Count > resp.TopIPs[j].Count
+		}
+		return resp.TopIPs[i].IP < resp.TopIPs[j].IP
+	})
+	return resp
+}
+
+// handleQueryStats serves GET /admin/stats with the query counts by
+// category and the most queried IPs since startup.
+func handleQueryStats(blocklists *Blocklists) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if blocklists.queries == nil {
+			http.Error(w, "query statistics are off, see top_queried", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, blocklists.queries.snapshot())
+	}
+}
+
+// ipCountHeap implements heap.Interface, least count first.
+type ipCountHeap []*ipCount
+
+func (h ipCountHeap) Len() int           { return len(h) }
+func (h ipCountHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h ipCountHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ipCountHeap) Push(x any) {
+	counter := x.(*ipCount)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *ipCountHeap) Pop() any {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return counter
+}
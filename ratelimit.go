@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientIdleTimeout is how long a client's bucket is kept after its last
+// query. A fresh bucket starts full, so forgetting idle clients is harmless.
+const clientIdleTimeout = 5 * time.Minute
+
+// maxClients caps the client buckets kept at once. UDP source addresses
+// are easily spoofed, so past the cap new clients share one bucket instead
+// of growing the map without bound.
+const maxClients = 100_000
+
+// Clients are bucketed by network rather than address, one IPv6 customer
+// usually gets a whole /56 and a host can hop between its addresses.
+const (
+	clientPrefixV4 = 24
+	clientPrefixV6 = 56
+)
+
+// rateLimiter applies token buckets per client network and, optionally, to
+// all queries together. A zero rate disables that bucket.
+type rateLimiter struct {
+	global *rate.Limiter
+
+	clientRate  rate.Limit
+	clientBurst int
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	// overflow is shared by the clients that found the map full
+	overflow *rate.Limiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{
+		clientRate:  rate.Limit(cfg.ClientQPS),
+		clientBurst: cfg.ClientBurst,
+		clients:     make(map[string]*clientLimiter),
+		overflow:    rate.NewLimiter(rate.Limit(cfg.ClientQPS), cfg.ClientBurst),
+	}
+	if cfg.GlobalQPS > 0 {
+		l.global = rate.NewLimiter(rate.Limit(cfg.GlobalQPS), cfg.GlobalBurst)
+	}
+	if cfg.ClientQPS > 0 {
+		go l.forgetIdleClients()
+	}
+	return l
+}
+
+// allow reports whether a query from addr may be answered, taking a token
+// from the client's bucket and the global one.
+func (l *rateLimiter) allow(addr net.Addr) bool {
+	if l.clientRate > 0 && !l.client(clientKey(addr)).Allow() {
+		return false
+	}
+	return l.global == nil || l.global.Allow()
+}
+
+func (l *rateLimiter) client(host string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[host]
+	if !ok {
+		if len(l.clients) >= maxClients {
+			return l.overflow
+		}
+		c = &clientLimiter{limiter: rate.NewLimiter(l.clientRate, l.clientBurst)}
+		l.clients[host] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+func (l *rateLimiter) forgetIdleClients() {
+	for range time.Tick(clientIdleTimeout) {
+		l.forgetIdleClientsAt(time.Now())
+	}
+}
+
+func (l *rateLimiter) forgetIdleClientsAt(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for host, c := range l.clients {
+		if now.Sub(c.lastSeen) > clientIdleTimeout {
+			delete(l.clients, host)
+		}
+	}
+}
+
+// clientKey names the bucket of addr: its /24 or /56, so every socket and
+// nearby address of a client shares one.
+func clientKey(addr net.Addr) string {
+	var host net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		host = a.IP
+	case *net.TCPAddr:
+		host = a.IP
+	default:
+		h, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			h = addr.String()
+		}
+		host = net.ParseIP(h)
+	}
+
+	if v4 := host.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(clientPrefixV4, 8*net.IPv4len)).String()
+	}
+	if v6 := host.To16(); v6 != nil {
+		return v6.Mask(net.CIDRMask(clientPrefixV6, 8*net.IPv6len)).String()
+	}
+	return addr.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 53}, "198.51.100.0"},
+		{&net.UDPAddr{IP: net.IPv4(198, 51, 100, 200).To4(), Port: 1}, "198.51.100.0"},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:198.51.100.7"), Port: 53}, "198.51.100.0"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8:1:ff::1"), Port: 53}, "2001:db8:1::"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:100::1"), Port: 53}, "2001:db8:1:100::"},
+		{&net.IPAddr{IP: net.ParseIP("203.0.113.9")}, "203.0.113.0"},
+	}
+	for _, tt := range tests {
+		if got := clientKey(tt.addr); got != tt.want {
+			t.Errorf("clientKey(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterCapsClients(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{ClientQPS: 1, ClientBurst: 1})
+	for i := 0; i < maxClients; i++ {
+		l.client(strconv.Itoa(i))
+	}
+
+	if got := l.client("spoofed"); got != l.overflow {
+		t.Error("client past the cap got a bucket of its own, want the shared one")
+	}
+	if len(l.clients) != maxClients {
+		t.Errorf("tracking %d clients, want the cap of %d", len(l.clients), maxClients)
+	}
+	if got := l.client("0"); got == l.overflow {
+		t.Error("known client got the shared bucket, want its own")
+	}
+
+	l.forgetIdleClientsAt(time.Now().Add(2 * clientIdleTimeout))
+	if len(l.clients) != 0 {
+		t.Errorf("tracking %d clients after they went idle, want 0", len(l.clients))
+	}
+	if got := l.client("spoofed"); got == l.overflow {
+		t.Error("client got the shared bucket below the cap, want its own")
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{ClientQPS: 0.001, ClientBurst: 2})
+	first := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1000}
+	neighbour := &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 2000}
+	other := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1000}
+
+	if !l.allow(first) || !l.allow(neighbour) {
+		t.Fatal("burst refused")
+	}
+	if l.allow(first) {
+		t.Error("same /24 allowed past its burst")
+	}
+	if !l.allow(other) {
+		t.Error("other /24 refused")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import "net/http"
+
+// refreshRequest asks a periodicUpdate to run now. It answers with the
+// update's error on the channel, unless the channel is nil as for SIGHUP.
+type refreshRequest chan error
+
+// refresher is the way into the periodicUpdate of one source, so manual
+// refreshes queue behind a running update instead of downloading again
+// alongside it.
+type refresher struct {
+	source   string
+	requests chan refreshRequest
+}
+
+type reloadResult struct {
+	Source  string `json:"source"`
+	Entries int    `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleReload serves POST /admin/reload, refreshing every source, or just
+// the one named by ?source=, and answering with the entries each holds
+// afterwards.
+func handleReload(blocklists *Blocklists, refresh []refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		selected := refresh
+		if name := r.URL.Query().Get("source"); name != "" {
+			selected = nil
+			for _, s := range refresh {
+				if s.source == name {
+					selected = append(selected, s)
+				}
+			}
+			if len(selected) == 0 {
+				http.Error(w, "unknown source "+name, http.StatusNotFound)
+				return
+			}
+		}
+
+		done := make([]refreshRequest, len(selected))
+		for i, s := range selected {
+			done[i] = make(refreshRequest, 1)
+			select {
+			case s.requests <- done[i]:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		results := make([]reloadResult, len(selected))
+		for i, s := range selected {
+			results[i].Source = s.source
+			select {
+			case err := <-done[i]:
+				if err != nil {
+					results[i].Error = err.Error()
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		blocklists.statusesMu.Lock()
+		for i := range results {
+			if status, ok := blocklists.statuses[results[i].Source]; ok {
+				results[i].Entries = status.entries
+			}
+		}
+		blocklists.statusesMu.Unlock()
+
+		writeJSON(w, http.StatusOK, results)
+	}
+}
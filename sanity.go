@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+)
+
+// checkListSize decides whether a freshly parsed list of n entries may
+// replace the one in use. Empty lists and lists that shrank below the
+// configured MinListRatio are rejected so the previous list keeps
+// protecting. Accepted lists are checked against min_entries.
+func (b *Blocklists) checkListSize(source string, n int) error {
+	b.acceptedSizesMu.Lock()
+	defer b.acceptedSizesMu.Unlock()
+
+	previous := b.acceptedSizes[source]
+	if n == 0 {
+		slog.Warn("Rejected download without valid entries, keeping the previous list",
+			"source", source, "previous", previous)
+		return fmt.Errorf("%s: download has no valid entries", source)
+	}
+	if float64(n) < float64(previous)*b.cfg.MinListRatio {
+		slog.Warn("Rejected download that shrank suspiciously, keeping the previous list",
+			"source", source, "count", n, "previous", previous)
+		return fmt.Errorf("%s: download has %d entries, down from %d", source, n, previous)
+	}
+
+	b.acceptedSizes[source] = n
+	b.checkMinEntries(source, n)
+	return nil
+}
+
+// checkMinEntries warns when an accepted list is smaller than the
+// configured min_entries of its source. Unlike MinListRatio this never
+// rejects the list, it only flags a feed that may have come back partial.
+func (b *Blocklists) checkMinEntries(source string, n int) {
+	minEntries, ok := b.cfg.MinEntries[source]
+	if !ok {
+		return
+	}
+
+	listBelowMinEntries.WithLabelValues(source).Set(0)
+	if n < minEntries {
+		slog.Warn("List has fewer entries than expected", "source", source, "count", n, "min_entries", minEntries)
+		listBelowMinEntries.WithLabelValues(source).Set(1)
+	}
+}
+
+// acceptedSize returns the entry count of the list of source in use, 0 when
+// none has been accepted yet.
+func (b *Blocklists) acceptedSize(source string) int {
+	b.acceptedSizesMu.Lock()
+	defer b.acceptedSizesMu.Unlock()
+
+	return b.acceptedSizes[source]
+}
+
+// capEntries trims a download from source to the max_entries of its
+// category, less the entries the category's other sources hold. Networks
+// are kept before single IPs, the widest first, since they cover the most
+// addresses per entry. When nothing fits the download is rejected like an
+// empty one. Lists of one category updating at the same time may overshoot
+// the cap until their next update.
+func (b *Blocklists) capEntries(source, category string, ips []net.IP, networks []*net.IPNet) ([]net.IP, []*net.IPNet, error) {
+	limit, ok := b.cfg.MaxEntries[category]
+
+	b.acceptedSizesMu.Lock()
+	b.categories[source] = category
+	if ok {
+		for other, otherCategory := range b.categories {
+			if other != source && otherCategory == category {
+				limit -= b.acceptedSizes[other]
+			}
+		}
+	}
+	b.acceptedSizesMu.Unlock()
+
+	if !ok {
+		return ips, networks, nil
+	}
+	limit = max(limit, 0)
+	n := len(ips) + len(networks)
+	listCappedEntries.WithLabelValues(source).Set(float64(max(n-limit, 0)))
+	if n <= limit {
+		return ips, networks, nil
+	}
+	if limit == 0 {
+		slog.Warn("Rejected download, its category is already at max_entries, keeping the previous list",
+			"source", source, "category", category, "count", n)
+		return nil, nil, fmt.Errorf("%s: no room left under max_entries of %s", source, category)
+	}
+
+	if len(networks) > limit {
+		networks = slices.Clone(networks)
+		slices.SortStableFunc(networks, func(a, b *net.IPNet) int {
+			return hostBits(b) - hostBits(a)
+		})
+		ips, networks = nil, networks[:limit]
+	} else {
+		ips = ips[:limit-len(networks)]
+	}
+	slog.Warn("List exceeds the max_entries of its category, dropping the excess",
+		"source", source, "category", category, "kept", limit, "dropped", n-limit)
+	return ips, networks, nil
+}
+
+func hostBits(network *net.IPNet) int {
+	ones, bits := network.Mask.Size()
+	return bits - ones
+}
@@ -0,0 +1,12 @@
+package main
+
+// sourceScore is the reputation score of a classification: how many
+// independent sources matched, so an IP on firehol, ipsum and the Tor list
+// scores 3. Allowlisted, private and reserved addresses score 0.
+func sourceScore(sources []string) int {
+	seen := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		seen[source] = true
+	}
+	return len(seen)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/scmmishra/ipshield/internal/ip"
+)
+
+// sourceUpdate returns the update of src. Its entries are handed to store
+// once they passed the size checks, the exact addresses and the networks
+// apart.
+func (b *Blocklists) sourceUpdate(name string, src ip.Source, store func(ip.IPSet, *ip.PrefixTrie)) listUpdate {
+	return listUpdate{src.Name(), name, func(ctx context.Context) error {
+		return b.updateSource(ctx, src, store)
+	}}
+}
+
+// updateSource fetches src once and publishes it through store. Partial
+// results, from sources made of several downloads, are only kept when
+// there is nothing better loaded, and the error is returned either way.
+func (b *Blocklists) updateSource(ctx context.Context, src ip.Source, store func(ip.IPSet, *ip.PrefixTrie)) error {
+	ips, networks, err := src.Fetch(ctx)
+	if errors.Is(err, ip.ErrNotModified) {
+		slog.Info("List unchanged since last download", "source", src.Name())
+		return nil
+	}
+	if err != nil && (len(ips)+len(networks) == 0 || b.acceptedSize(src.Name()) > 0) {
+		return err
+	}
+	ips, networks, capErr := b.capEntries(src.Name(), src.Category(), ips, networks)
+	if capErr != nil {
+		return errors.Join(err, capErr)
+	}
+
+	set := make(ip.IPSet, len(ips))
+	for _, addr := range ips {
+		set.Add(addr)
+	}
+	trie := ip.NewPrefixTrie(networks)
+	count := len(set) + trie.Len()
+	if sizeErr := b.checkListSize(src.Name(), count); sizeErr != nil {
+		return errors.Join(err, sizeErr)
+	}
+
+	store(set, trie)
+
+	slog.Info("Loaded list", "source", src.Name(), "category", src.Category(), "count", count)
+	b.recordEntries(src.Name(), count)
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestRejectedDownloadIsFetchedAgain checks that a download failing the
+// size check doesn't leave its ETag behind, so the next poll downloads it
+// again and keeps failing rather than taking a 304 for success.
+func TestRejectedDownloadIsFetchedAgain(t *testing.T) {
+	var mu sync.Mutex
+	var body, etag string
+	var downloads int
+	serve := func(n int, tag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		var lines []string
+		for i := 0; i < n; i++ {
+			lines = append(lines, fmt.Sprintf("185.220.101.%d", i+1))
+		}
+		body, etag = strings.Join(lines, "\n")+"\n", tag
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.Sources.Tor = server.URL + "/tor.txt"
+	b := NewBlocklists(cfg)
+	var tor listUpdate
+	for _, u := range b.updates() {
+		if u.source == "tor" {
+			tor = u
+		}
+	}
+
+	steps := []struct {
+		entries  int
+		etag     string
+		wantErr  bool
+		failures int
+	}{
+		{100, `"full"`, false, 0},
+		{100, `"full"`, false, 0}, // 304
+		{1, `"shrunk"`, true, 1},
+		{1, `"shrunk"`, true, 2}, // would be a 304 had the ETag been kept
+		{100, `"full-again"`, false, 0},
+	}
+	for i, step := range steps {
+		serve(step.entries, step.etag)
+		err := b.run(context.Background(), tor)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("step %d: update error = %v, want error %v", i, err, step.wantErr)
+		}
+		b.statusesMu.Lock()
+		failures := b.sourceStatusLocked("tor").failures
+		b.statusesMu.Unlock()
+		if failures != step.failures {
+			t.Errorf("step %d: %d failures recorded, want %d", i, failures, step.failures)
+		}
+		if got := len(b.snapshot().torExit); got != 100 {
+			t.Errorf("step %d: serving %d Tor exits, want the 100 of the accepted list", i, got)
+		}
+	}
+	if downloads != 4 {
+		t.Errorf("list downloaded %d times, want 4, all but the unchanged poll", downloads)
+	}
+}
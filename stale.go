@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// checkStalenessLocked marks every source whose last successful update is
+// older than its maximum age, counting from startup for sources that never
+// loaded. Lists only age while their updates fail, and failing updates are
+// retried at least every max_retry_delay, so running this after every
+// attempt is enough to notice.
+func (b *Blocklists) checkStalenessLocked(now time.Time) {
+	anyStale := false
+	for source, status := range b.statuses {
+		maxAge := b.cfg.Staleness.maxAge(source)
+		since := status.lastSuccess
+		if since.IsZero() {
+			since = b.started
+		}
+
+		stale := maxAge > 0 && now.Sub(since) > maxAge
+		if stale && !status.stale {
+			slog.Warn("List is stale", "source", source, "max_age", maxAge.String(), "policy", b.cfg.Staleness.Policy)
+		}
+		status.stale = stale
+		anyStale = anyStale || stale
+
+		listStale.WithLabelValues(source).Set(0)
+		if stale {
+			listStale.WithLabelValues(source).Set(1)
+		}
+	}
+	b.stale.Store(anyStale)
+}
+
+// degraded reports whether stale lists should show in answers and
+// readiness.
+func (b *Blocklists) degraded() bool {
+	return b.cfg.Staleness.Policy == stalenessDegrade && b.stale.Load()
+}
+
+// staleSources lists the sources currently stale, sorted.
+func (b *Blocklists) staleSources() []string {
+	b.statusesMu.Lock()
+	defer b.statusesMu.Unlock()
+
+	var sources []string
+	for source, status := range b.statuses {
+		if status.stale {
+			sources = append(sources, source)
+		}
+	}
+	slices.Sort(sources)
+	return sources
+}
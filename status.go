@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxStatusString is the longest string a TXT record can carry.
+const maxStatusString = 255
+
+// sourceStatus is what the status TXT reports for one list.
+type sourceStatus struct {
+	lastSuccess time.Time
+	nextUpdate  time.Time
+	entries     int
+
+	// Set while the latest attempts failed, cleared by the next success
+	failures     int
+	failingSince time.Time
+	lastError    string
+	reported     bool
+
+	stale bool
+}
+
+func (b *Blocklists) sourceStatusLocked(source string) *sourceStatus {
+	status, ok := b.statuses[source]
+	if !ok {
+		status = &sourceStatus{}
+		b.statuses[source] = status
+	}
+	return status
+}
+
+func (b *Blocklists) recordEntries(source string, n int) {
+	listEntries.WithLabelValues(source).Set(float64(n))
+
+	b.statusesMu.Lock()
+	b.sourceStatusLocked(source).entries = n
+	b.statusesMu.Unlock()
+}
+
+// recordUpdate notes an update attempt, so failing lists are listed too. A
+// source failing for more than cfg.FailingIntervals update intervals is
+// logged once as an error and flagged in the status TXT and metrics.
+func (b *Blocklists) recordUpdate(source string, at time.Time, err error) {
+	b.statusesMu.Lock()
+	defer b.statusesMu.Unlock()
+
+	defer b.checkStalenessLocked(at)
+
+	status := b.sourceStatusLocked(source)
+	if err == nil {
+		status.lastSuccess = at
+		status.failures, status.failingSince, status.lastError, status.reported = 0, time.Time{}, "", false
+		recordUpdateMetrics(source, at, 0, false)
+		return
+	}
+
+	if status.failures == 0 {
+		status.failingSince = at
+	}
+	status.failures++
+	status.lastError = err.Error()
+
+	failing := b.failingLocked(status, at)
+	if failing && !status.reported {
+		status.reported = true
+		slog.Error("Source keeps failing to update", "source", source,
+			"since", status.failingSince, "failures", status.failures, "error", err)
+	}
+	recordUpdateMetrics(source, at, status.failures, failing)
+}
+
+// failingLocked reports whether status has been failing for longer than the
+// configured number of update intervals.
+func (b *Blocklists) failingLocked(status *sourceStatus, now time.Time) bool {
+	limit := time.Duration(b.cfg.FailingIntervals) * b.cfg.UpdateInterval
+	return status.failures > 0 && now.Sub(status.failingSince) > limit
+}
+
+// recordNextUpdate notes when source is next due for its regular update. A
+// zero at clears it while the source is retrying, otherwise a broken
+// upstream would keep answer TTLs at their floor.
+func (b *Blocklists) recordNextUpdate(source string, at time.Time) {
+	b.statusesMu.Lock()
+	defer b.statusesMu.Unlock()
+
+	b.sourceStatusLocked(source).nextUpdate = at
+
+	var earliest time.Time
+	for _, status := range b.statuses {
+		if !status.nextUpdate.IsZero() && (earliest.IsZero() || status.nextUpdate.Before(earliest)) {
+			earliest = status.nextUpdate
+		}
+	}
+	if earliest.IsZero() {
+		b.nextRefresh.Store(0)
+	} else {
+		b.nextRefresh.Store(earliest.UnixNano())
+	}
+}
+
+// statusStrings describes every list as "source updated=<RFC 3339>
+// entries=<n>", sorted by source. Lists that never loaded show
+// updated=never. Failing lists add "failures=<n> error=<last error>". Lists
+// failing for too long are prefixed with FAILING, and stale ones with STALE.
+// Before any update was recorded it is a lone "no updates yet", a TXT
+// record needs at least one string.
+func (b *Blocklists) statusStrings() []string {
+	b.statusesMu.Lock()
+	defer b.statusesMu.Unlock()
+
+	if len(b.statuses) == 0 {
+		return []string{"no updates yet"}
+	}
+
+	sources := make([]string, 0, len(b.statuses))
+	for source := range b.statuses {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+
+	now := time.Now()
+	lines := make([]string, 0, len(sources))
+	for _, source := range sources {
+		status := b.statuses[source]
+		updated := "never"
+		if !status.lastSuccess.IsZero() {
+			updated = status.lastSuccess.UTC().Format(time.RFC3339)
+		}
+		line := fmt.Sprintf("%s updated=%s entries=%d", source, updated, status.entries)
+		if status.failures > 0 {
+			line += fmt.Sprintf(" failures=%d error=%s", status.failures, status.lastError)
+		}
+		if status.stale {
+			line = "STALE " + line
+		}
+		if b.failingLocked(status, now) {
+			line = "FAILING " + line
+		}
+		if len(line) > maxStatusString {
+			line = line[:maxStatusString]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// isStatusName reports whether a question asks for the status TXT. An empty
+// statusName disables it.
+func isStatusName(name, statusName string) bool {
+	return statusName != "" && strings.EqualFold(strings.TrimSuffix(name, "."), statusName)
+}
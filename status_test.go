@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestStatusTXT(t *testing.T) {
+	cfg := defaultConfig()
+	b := NewBlocklists(cfg)
+	handler := handleRequest(cfg, b)
+
+	status := func() *dns.TXT {
+		t.Helper()
+		m := exchange(t, handler, cfg.StatusName, dns.TypeTXT)
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			t.Fatalf("status answered %s with %d records, want NOERROR and one TXT", dns.RcodeToString[m.Rcode], len(m.Answer))
+		}
+		if _, err := m.Pack(); err != nil {
+			t.Fatalf("status reply doesn't pack: %v", err)
+		}
+		rr := m.Answer[0].(*dns.TXT)
+		if rr.Hdr.Class != dns.ClassINET || rr.Hdr.Ttl != 0 {
+			t.Errorf("status TXT has class %s and TTL %d, want IN and 0", dns.ClassToString[rr.Hdr.Class], rr.Hdr.Ttl)
+		}
+		return rr
+	}
+
+	// Also before any list is loaded, which fails other queries closed
+	if got := status().Txt; len(got) != 1 || got[0] != "no updates yet" {
+		t.Errorf("status before any update = %q, want \"no updates yet\"", got)
+	}
+
+	b.recordEntries("tor", 1200)
+	updated := time.Now().UTC()
+	b.recordUpdate("tor", updated, nil)
+	b.recordUpdate("firehol", time.Now(), errors.New("test failure"))
+	want := []string{
+		"firehol updated=never entries=0 failures=1 error=test failure",
+		"tor updated=" + updated.Format(time.RFC3339) + " entries=1200",
+	}
+	if got := status().Txt; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("status = %q, want %q", got, want)
+	}
+}
+
+func TestStatusRedactsPresignedURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "signature does not match", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.CacheDir = t.TempDir()
+	cfg.Sources.Drop = server.URL + "/drop.txt?X-Amz-Credential=AKIDEXAMPLE&X-Amz-Signature=s3cr3t"
+	cfg.Sources.Edrop = ""
+	b := NewBlocklists(cfg)
+	for _, u := range b.updates() {
+		if u.source == "drop" {
+			if err := b.run(context.Background(), u); err == nil {
+				t.Fatal("update of an unreachable DROP list succeeded")
+			}
+		}
+	}
+
+	m := exchange(t, handleRequest(cfg, b), cfg.StatusName, dns.TypeTXT)
+	txt := strings.Join(m.Answer[0].(*dns.TXT).Txt, "|")
+	if !strings.Contains(txt, "drop ") || !strings.Contains(txt, "failures=1") {
+		t.Fatalf("status = %q, want the failed drop update", txt)
+	}
+	if strings.Contains(txt, "s3cr3t") || strings.Contains(txt, "AKIDEXAMPLE") {
+		t.Errorf("status = %q, leaks the presigned query string", txt)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// minAnswerTTL keeps answers cacheable for a little while even right before
+// a list update.
+const minAnswerTTL = 30 * time.Second
+
+// answerTTL is the TTL of an answer whose highest priority category is
+// category. A TTL configured for the category is used as is. Otherwise it is
+// the cache TTL, shortened so that caches expire answers around the next
+// scheduled list update rather than serving them stale for up to a full TTL
+// afterwards.
+func (b *Blocklists) answerTTL(now time.Time, category string) uint32 {
+	if ttl, ok := b.cfg.CategoryTTL[category]; ok {
+		return uint32(ttl / time.Second)
+	}
+
+	cacheTTL := b.cfg.CacheTTL
+	ttl := cacheTTL
+	if next := b.nextRefresh.Load(); next != 0 {
+		ttl = min(ttl, time.Unix(0, next).Sub(now))
+	}
+	ttl = max(ttl, min(minAnswerTTL, cacheTTL))
+	return uint32(ttl / time.Second)
+}
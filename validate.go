@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// runValidate implements -validate: it downloads and parses every enabled
+// source once, without binding any port, and returns a non-zero exit code
+// if any of them failed. Meant as a preflight check of a config change.
+//
+// Each source gets one tab separated line of its name, the entries loaded
+// and "ok" or the error, e.g. "firehol	4521	ok".
+func runValidate(cfg *Config, stdout io.Writer) int {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	blocklists := NewBlocklists(cfg)
+	updates := blocklists.updates()
+	errs := blocklists.runUpdates(context.Background(), updates)
+
+	blocklists.statusesMu.Lock()
+	defer blocklists.statusesMu.Unlock()
+
+	exitCode := 0
+	for i, update := range updates {
+		entries := "-"
+		if status, ok := blocklists.statuses[update.source]; ok && status.entries > 0 {
+			entries = strconv.Itoa(status.entries)
+		}
+		result := "ok"
+		if errs[i] != nil {
+			result = errs[i].Error()
+			exitCode = 1
+		}
+		fmt.Fprintf(stdout, "%s\t%s\t%s\n", update.source, entries, result)
+	}
+	return exitCode
+}